@@ -0,0 +1,184 @@
+package bikage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MilestoneDistances are the cumulative distances celebrated by Achievements,
+// in meters
+var MilestoneDistances = []uint64{100000, 500000, 1000000, 2500000, 5000000, 10000000}
+
+// Achievements gamify the trip history, days are computed in the zone of the
+// user
+type Achievements struct {
+	FirstTrip *Trip
+
+	// CurrentStreak ends today or yesterday, it is zero once broken
+	CurrentStreak Streak
+	LongestStreak Streak
+
+	// LongestTrip is the longest by distance, FastestTrip leaves the speed
+	// outliers out, see MinTripSpeed
+	LongestTrip *PersonalRecord
+	FastestTrip *PersonalRecord
+	BusiestDay  *Bucket
+
+	Milestones []Milestone
+	// NewStations lists the first trip from or to each station, in order
+	NewStations []StationFirst
+}
+
+// Streak is a run of consecutive days with at least one trip
+type Streak struct {
+	From Date
+	To   Date
+	Days int
+}
+
+type PersonalRecord struct {
+	Trip     Trip
+	Distance uint64 // meters
+	Speed    float64
+}
+
+// Milestone is reached by the trip taking the total distance past Distance
+type Milestone struct {
+	Distance uint64 // meters
+	Date     Date
+	Trip     Trip
+}
+
+type StationFirst struct {
+	Station Station
+	Date    Date
+	Trip    Trip
+}
+
+// ComputeAchievements computes the achievements of the trips, streaks end
+// today at the latest
+func (bk *Bikage) ComputeAchievements(ctx context.Context, trips Trips) *Achievements {
+	distances := bk.RouteAPI.GetAll(ctx, trips)
+
+	return bk.compute_achievements(trips, distances, bk.compute_stats(trips, distances), bk.Date(time.Now()))
+}
+
+func (bk *Bikage) compute_achievements(trips Trips, distances map[Trip]uint64, stats *Stats, today Date) *Achievements {
+	sorted := make(Trips, len(trips))
+	copy(sorted, trips)
+	sort.Sort(sorted)
+
+	a := &Achievements{Milestones: make([]Milestone, 0), NewStations: make([]StationFirst, 0)}
+	if len(sorted) == 0 {
+		return a
+	}
+	a.FirstTrip = &sorted[0]
+
+	var streak Streak
+	var total uint64
+	visited := make(map[uint64]bool)
+
+	for _, trip := range sorted {
+		day := bk.Date(trip.StartedAt)
+
+		switch {
+		case streak.Days > 0 && day == streak.To:
+		case streak.Days > 0 && day == streak.To.AddDays(1):
+			streak.To = day
+			streak.Days++
+		default:
+			streak = Streak{From: day, To: day, Days: 1}
+		}
+		if streak.Days > a.LongestStreak.Days {
+			a.LongestStreak = streak
+		}
+
+		for _, station := range []Station{trip.Route.From, trip.Route.To} {
+			if !visited[station.Id] {
+				visited[station.Id] = true
+				a.NewStations = append(a.NewStations, StationFirst{station, day, trip})
+			}
+		}
+
+		distance, ok := distances[trip]
+		if !ok {
+			continue
+		}
+
+		if a.LongestTrip == nil || distance > a.LongestTrip.Distance {
+			speed, _ := trip_speed(distance, trip.Duration())
+			a.LongestTrip = &PersonalRecord{trip, distance, speed}
+		}
+		if speed, outlier := trip_speed(distance, trip.Duration()); !outlier && (a.FastestTrip == nil || speed > a.FastestTrip.Speed) {
+			a.FastestTrip = &PersonalRecord{trip, distance, speed}
+		}
+
+		for _, milestone := range MilestoneDistances {
+			if total < milestone && total+distance >= milestone {
+				a.Milestones = append(a.Milestones, Milestone{milestone, day, trip})
+			}
+		}
+		total += distance
+	}
+
+	if streak.To == today || streak.To == today.AddDays(-1) {
+		a.CurrentStreak = streak
+	}
+
+	for i, day := range stats.Daily {
+		if a.BusiestDay == nil || day.Distance > a.BusiestDay.Distance {
+			a.BusiestDay = &stats.Daily[i]
+		}
+	}
+
+	return a
+}
+
+func (a Achievements) String() string {
+	if a.FirstTrip == nil {
+		return "Achievements:\n  none yet"
+	}
+
+	lines := []string{
+		fmt.Sprintf("  first trip %s, %s", a.FirstTrip.StartedAt.Format("Jan 02 2006"), a.FirstTrip.Route),
+		fmt.Sprintf("  current streak %s", a.CurrentStreak),
+		fmt.Sprintf("  longest streak %s", a.LongestStreak),
+	}
+	if a.LongestTrip != nil {
+		lines = append(lines, fmt.Sprintf("  longest trip %.1f km, %s", km_dist(a.LongestTrip.Distance), a.LongestTrip.Trip))
+	}
+	if a.FastestTrip != nil {
+		lines = append(lines, fmt.Sprintf("  fastest trip %.1f km/h, %s", a.FastestTrip.Speed, a.FastestTrip.Trip))
+	}
+	if a.BusiestDay != nil {
+		lines = append(lines, fmt.Sprintf("  busiest day %s, %.1f km in %d trips", a.BusiestDay.Start, a.BusiestDay.Km(), a.BusiestDay.Trips))
+	}
+	for _, milestone := range a.Milestones {
+		lines = append(lines, fmt.Sprintf("  reached %.0f km on %s", km_dist(milestone.Distance), milestone.Date))
+	}
+
+	lines = append(lines, fmt.Sprintf("  %d stations visited", len(a.NewStations)))
+	latest := a.NewStations
+	if len(latest) > 5 {
+		latest = latest[len(latest)-5:]
+	}
+	for i := len(latest) - 1; i >= 0; i-- {
+		lines = append(lines, fmt.Sprintf("    first visit of %s on %s", latest[i].Station, latest[i].Date))
+	}
+
+	return "Achievements:\n" + strings.Join(lines, "\n")
+}
+
+func (s Streak) String() string {
+	switch s.Days {
+	case 0:
+		return "0 days"
+	case 1:
+		return fmt.Sprintf("1 day, %s", s.From)
+	}
+
+	return fmt.Sprintf("%d days, %s", s.Days, DateRange{s.From, s.To})
+}
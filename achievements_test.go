@@ -0,0 +1,93 @@
+package bikage_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/Bowbaq/bikage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ComputeAchievements()", func() {
+	route_api := test_route_api{}
+	bk := &Bikage{RouteAPI: &route_api, TripAPI: &test_trip_api{}, Location: time.UTC}
+
+	today := DateOf(time.Now().UTC())
+
+	a := Station{Id: 1, Label: "A"}
+	b := Station{Id: 2, Label: "B"}
+	c := Station{Id: 3, Label: "C"}
+
+	trip := func(days_ago, hour int, from, to Station, minutes int) Trip {
+		started := today.AddDays(-days_ago).Time(time.UTC).Add(time.Duration(hour) * time.Hour)
+		return Trip{
+			Id:        started.String(),
+			Route:     Route{From: from, To: to},
+			StartedAt: started,
+			EndedAt:   started.Add(time.Duration(minutes) * time.Minute),
+		}
+	}
+
+	first := trip(10, 8, a, b, 120)   // 40 km at 20 km/h
+	longest := trip(9, 8, b, a, 150)  // 50 km at 20 km/h
+	fastest := trip(8, 8, a, c, 30)   // 15 km at 30 km/h, past 100 km
+	back := trip(8, 18, c, a, 20)     // 5 km at 15 km/h
+	yesterday := trip(1, 8, a, b, 40) // 10 km at 15 km/h
+	idle := trip(0, 8, b, a, 120)     // 1 km, kept for two hours
+
+	// Out of order on purpose
+	trips := Trips{yesterday, first, idle, fastest, back, longest}
+
+	BeforeEach(func() {
+		route_api.get_all = func(trips Trips) map[Trip]uint64 {
+			return map[Trip]uint64{first: 40000, longest: 50000, fastest: 15000, back: 5000, yesterday: 10000, idle: 1000}
+		}
+	})
+
+	AfterEach(func() {
+		route_api.get_all = nil
+	})
+
+	It("finds the first trip and the personal records", func() {
+		achievements := bk.ComputeAchievements(context.Background(), trips)
+
+		Expect(achievements.FirstTrip.Id).To(Equal(first.Id))
+		Expect(achievements.LongestTrip.Trip.Id).To(Equal(longest.Id))
+		Expect(achievements.LongestTrip.Distance).To(BeNumerically("==", 50000))
+		Expect(achievements.FastestTrip.Trip.Id).To(Equal(fastest.Id))
+		Expect(achievements.FastestTrip.Speed).To(BeNumerically("~", 30, 0.01))
+		Expect(achievements.BusiestDay.Start).To(Equal(today.AddDays(-9)))
+	})
+
+	It("finds the longest and current streaks", func() {
+		achievements := bk.ComputeAchievements(context.Background(), trips)
+
+		Expect(achievements.LongestStreak).To(Equal(Streak{From: today.AddDays(-10), To: today.AddDays(-8), Days: 3}))
+		Expect(achievements.CurrentStreak).To(Equal(Streak{From: today.AddDays(-1), To: today, Days: 2}))
+	})
+
+	It("has no current streak once a day was missed", func() {
+		achievements := bk.ComputeAchievements(context.Background(), Trips{first, longest})
+		Expect(achievements.CurrentStreak.Days).To(BeZero())
+	})
+
+	It("records the milestones and the first visit of each station", func() {
+		achievements := bk.ComputeAchievements(context.Background(), trips)
+
+		Expect(achievements.Milestones).To(HaveLen(1))
+		Expect(achievements.Milestones[0].Distance).To(BeNumerically("==", 100000))
+		Expect(achievements.Milestones[0].Trip.Id).To(Equal(fastest.Id))
+
+		Expect(achievements.NewStations).To(HaveLen(3))
+		Expect(achievements.NewStations[2].Station).To(Equal(c))
+		Expect(achievements.NewStations[2].Date).To(Equal(today.AddDays(-8)))
+	})
+
+	It("has no achievements without trips", func() {
+		achievements := bk.ComputeAchievements(context.Background(), Trips{})
+		Expect(achievements.FirstTrip).To(BeNil())
+		Expect(achievements.LongestStreak.Days).To(BeZero())
+	})
+})
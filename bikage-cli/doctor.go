@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Bowbaq/bikage"
+)
+
+// doctor_cmd checks that the member portal can still be read: login, trip
+// history navigation and trip rows
+func doctor_cmd(ctx context.Context, args []string) {
+	flags := new_flag_set("doctor")
+	// The member portal is read without routing any trip, no API key needed
+	flags.Set("router", bikage.RouterEstimate)
+	parse_flags(flags, args)
+
+	bk := new_bikage(ctx)
+
+	diagnoser, ok := bk.TripAPI.(bikage.Diagnoser)
+	if !ok {
+		log.Fatalln("the trip history of", system, "can't be diagnosed")
+	}
+
+	d := diagnoser.Diagnose(ctx, username, password)
+
+	healthy := report("login", d.Login == nil, d.Login)
+	if d.Login == nil {
+		healthy = report("trips page", d.Page == nil, d.Page) && healthy
+	}
+	if d.Login == nil && d.Page == nil {
+		fmt.Printf("selector profile: %s\n", d.Profile)
+
+		navigation := fmt.Sprintf("%d pages", d.Pages)
+		if !d.Navigation {
+			navigation = "no links to other pages, expected if the history fits on one page"
+		}
+		report("navigation", true, navigation)
+
+		rows := fmt.Sprintf("%d of %d parsed", d.Trips, d.Rows)
+		healthy = report("trip rows", d.Rows > 0 && d.Trips > 0, rows) && healthy
+		for _, skipped := range d.Skipped {
+			fmt.Printf("  skipped %s\n", skipped)
+		}
+	}
+
+	if !healthy {
+		fmt.Println("\n" + explain_diagnosis(d))
+		os.Exit(1)
+	}
+}
+
+func report(check string, ok bool, detail interface{}) bool {
+	status := "ok"
+	if !ok {
+		status = "FAILED"
+	}
+
+	if detail == nil {
+		fmt.Printf("%-11s %s\n", check+":", status)
+	} else {
+		fmt.Printf("%-11s %s (%v)\n", check+":", status, detail)
+	}
+
+	return ok
+}
+
+func explain_diagnosis(d bikage.Diagnosis) string {
+	if d.Login != nil {
+		return explain(d.Login)
+	}
+	if d.Page != nil {
+		return explain(d.Page)
+	}
+
+	return "No trip could be read from the first page, the member portal layout may have changed or the history is empty"
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+
+	"github.com/Bowbaq/bikage/export"
+)
+
+func export_cmd(ctx context.Context, args []string) {
+	var format, output string
+
+	flags := new_flag_set("export")
+	flags.StringVar(&format, "format", export.GPX, "export format, gpx, kml or geojson")
+	flags.StringVar(&output, "o", "", "output file (optional, defaults to stdout)")
+	parse_flags(flags, args)
+
+	if export.ContentType(format) == "" {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	bk := new_bikage(ctx)
+
+	result := get_trips(ctx, bk)
+
+	routes := bk.RouteTrips(ctx, result.Trips)
+	write_output(output, func(w io.Writer) error {
+		return export.Write(w, format, routes)
+	})
+}
+
+// write_output writes to the output file, or stdout if there is none, and exits
+// if the file can't be written or closed
+func write_output(output string, write func(w io.Writer) error) {
+	if output == "" {
+		if err := write(os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	err = write(f)
+	if close_err := f.Close(); err == nil {
+		err = close_err
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"time"
 
 	"github.com/Bowbaq/bikage"
 )
@@ -15,33 +20,213 @@ var (
 
 	google_api_key string
 	mongo_url      string
+	system         string
+	gbfs_endpoint  string
+
+	router        string
+	detour_factor float64
+	osm_graph     string
+
+	match_threshold float64
+	full_sync       bool
+
+	trip_workers uint
+	trip_rate    int
+	trip_burst   int
+
+	trip_data string
+	time_zone string
 )
 
-func init() {
-	flag.StringVar(&username, "u", "", "citibike.com username (required)")
-	flag.StringVar(&password, "p", "", "citibike.com password (required)")
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string)
+}
 
-	flag.StringVar(&google_api_key, "google-api-key", "", "Google API key, directions API must be enabled (required)")
-	flag.StringVar(&mongo_url, "mongo-url", "", "MongoDB url (persistent distance cache) (optional, defaults to local JSON cache)")
+var commands = []command{
+	{"stats", "print distance statistics (default)", stats_cmd},
+	{"export", "export trips as gpx, kml or geojson", export_cmd},
+	{"trips", "back up or restore cached trips as csv or jsonl", trips_cmd},
+	{"stations", "review the station alias table", stations_cmd},
+	{"top", "list the most used stations or routes", top_cmd},
+	{"doctor", "check that the member portal can still be read", doctor_cmd},
 }
 
 func main() {
-	flag.Parse()
+	args := os.Args[1:]
+
+	name := "stats"
+	if len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+		name, args = args[0], args[1:]
+	}
+
+	// Interrupting stops the requests in flight, the trips fetched so far are
+	// kept in the cache
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			cmd.run(ctx, args)
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Usage: bikage-cli [command] [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.usage)
+	}
+	os.Exit(1)
+}
+
+// new_flag_set returns a flag set with the flags shared by all commands
+func new_flag_set(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
 
-	if username == "" || password == "" || google_api_key == "" {
-		flag.Usage()
+	flags.StringVar(&username, "u", "", "member portal username (required)")
+	flags.StringVar(&password, "p", "", "member portal password (required)")
+
+	flags.StringVar(&google_api_key, "google-api-key", "", "Google API key, directions API must be enabled (required)")
+	flags.StringVar(&mongo_url, "mongo-url", "", "MongoDB url (persistent distance cache) (optional, defaults to local JSON cache)")
+	flags.StringVar(&system, "system", bikage.DefaultSystem, "bike share system, one of "+strings.Join(bikage.SystemIds(), ", "))
+	flags.StringVar(&gbfs_endpoint, "gbfs", "", "GBFS gbfs.json url or file, source of the station list (optional, defaults to the system feed)")
+
+	flags.StringVar(&router, "router", bikage.RouterGoogle, "distance router, google, estimate or osm (offline, no API key needed)")
+	flags.Float64Var(&detour_factor, "detour-factor", bikage.DefaultDetourFactor, "ratio between street and straight line distance (estimate router only)")
+	flags.StringVar(&osm_graph, "osm-graph", "", "path to an OpenStreetMap bike graph (osm router only)")
+
+	flags.Float64Var(&match_threshold, "match-threshold", bikage.DefaultMatchThreshold, "similarity above which unknown station names are matched automatically")
+
+	flags.BoolVar(&full_sync, "full", false, "download the whole trip history, not just the trips that aren't cached yet")
+	flags.UintVar(&trip_workers, "workers", bikage.DefaultTripWorkers, "number of trip history pages fetched concurrently")
+	flags.IntVar(&trip_rate, "rate", bikage.DefaultTripRate, "trip history pages fetched per second")
+	flags.IntVar(&trip_burst, "burst", bikage.DefaultTripBurst, "trip history pages fetched at once above the rate")
+
+	flags.StringVar(&time_zone, "tz", "", "time zone days are computed in, e.g. Europe/Paris (optional, defaults to the system zone)")
+	flags.StringVar(&trip_data, "trip-data", "", "comma separated system data files (csv or zip), replaces the member trip history")
+
+	return flags
+}
+
+// parse_flags parses the command line and exits if a required flag is missing
+func parse_flags(flags *flag.FlagSet, args []string) {
+	flags.Parse(args)
+
+	if (trip_data == "" && (username == "" || password == "")) || (router == bikage.RouterGoogle && google_api_key == "") {
+		flags.Usage()
 		os.Exit(1)
 	}
+}
+
+func new_bikage(ctx context.Context) *bikage.Bikage {
+	var trip_data_paths []string
+	if trip_data != "" {
+		trip_data_paths = strings.Split(trip_data, ",")
+	}
 
-	bikage, err := bikage.NewBikage(google_api_key, mongo_url)
+	bk, err := bikage.NewBikage(ctx, bikage.Config{
+		GoogleAPIKey:   google_api_key,
+		MongoURL:       mongo_url,
+		System:         system,
+		GBFSEndpoint:   gbfs_endpoint,
+		Router:         router,
+		DetourFactor:   detour_factor,
+		OSMGraphPath:   osm_graph,
+		MatchThreshold: match_threshold,
+		FullSync:       full_sync,
+		TripWorkers:    trip_workers,
+		TripRate:       trip_rate,
+		TripBurst:      trip_burst,
+		TimeZone:       time_zone,
+		TripDataPaths:  trip_data_paths,
+	})
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	trips, err := bikage.GetTrips(username, password)
+	return bk
+}
+
+func stats_cmd(ctx context.Context, args []string) {
+	var compare string
+
+	flags := new_flag_set("stats")
+	params := add_query_flags(flags)
+	flags.StringVar(&compare, "compare", "", "compare the period with an earlier one: week, month, ytd (so far, up to -to), yoy or previous (-from to -to) (optional)")
+	parse_flags(flags, args)
+
+	bk := new_bikage(ctx)
+	query := parse_query(params, bk.Location)
+
+	result := get_trips(ctx, bk)
+	print_unmatched(bk.Matcher)
+
+	if compare == "" {
+		trips := query.Apply(result.Trips)
+		fmt.Println(bk.ComputeStats(ctx, trips))
+		fmt.Println(bk.ComputeAchievements(ctx, trips))
+		return
+	}
+
+	// The periods compared replace -from and -to
+	r, err := bikage.ParseDateRange(*params["from"], *params["to"])
 	if err != nil {
 		log.Fatalln(err)
 	}
+	query.From, query.To = time.Time{}, time.Time{}
+
+	comparison, err := bk.CompareStats(ctx, query.Apply(result.Trips), bikage.Comparison(compare), r)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	fmt.Println(comparison)
+}
+
+// get_trips fetches the trip history, exits unless at least part of it could
+// be fetched
+func get_trips(ctx context.Context, bk *bikage.Bikage) bikage.TripsResult {
+	result, err := bk.GetTrips(ctx, username, password)
+	if err != nil && len(result.Trips) == 0 {
+		log.Fatalln(explain(err))
+	}
+	if err != nil {
+		log.Println("Trip history incomplete ->", err)
+	}
+	print_result(result)
 
-	fmt.Println(bikage.ComputeStats(trips))
+	return result
+}
+
+// explain turns the trip history errors into actionable messages
+func explain(err error) string {
+	switch {
+	case errors.Is(err, bikage.ErrInvalidCredentials):
+		return "Login failed, check the username (-u) and password (-p)"
+	case errors.Is(err, bikage.ErrCSRFNotFound), errors.Is(err, bikage.ErrLayoutChanged):
+		return "The member portal changed and can't be read anymore, see bikage-cli doctor (" + err.Error() + ")"
+	case errors.Is(err, bikage.ErrRateLimited):
+		return "The member portal is rate limiting requests, try again later or lower -rate"
+	}
+
+	return err.Error()
+}
+
+// print_result reports the trips that couldn't be parsed and the pages that
+// couldn't be fetched, they are missing from the stats
+func print_result(result bikage.TripsResult) {
+	if len(result.Skipped) > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped %d trips that couldn't be parsed:\n", len(result.Skipped))
+		for _, trip := range result.Skipped {
+			fmt.Fprintf(os.Stderr, "  %s\n", trip)
+		}
+	}
+
+	if len(result.Failed) > 0 {
+		fmt.Fprintf(os.Stderr, "Couldn't fetch %d pages of the trip history:\n", len(result.Failed))
+		for _, page := range result.Failed {
+			fmt.Fprintf(os.Stderr, "  %s\n", page)
+		}
+	}
 }
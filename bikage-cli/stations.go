@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bowbaq/bikage"
+)
+
+func stations_cmd(ctx context.Context, args []string) {
+	if len(args) == 0 || args[0] != "alias" {
+		stations_usage()
+	}
+
+	flags := flag.NewFlagSet("stations alias", flag.ExitOnError)
+	flags.StringVar(&mongo_url, "mongo-url", "", "MongoDB url (optional, defaults to local JSON cache)")
+	flags.StringVar(&system, "system", bikage.DefaultSystem, "bike share system, one of "+strings.Join(bikage.SystemIds(), ", "))
+	flags.Parse(args[1:])
+
+	cache := new_cache()
+	matcher := bikage.NewStationMatcher(bikage.NewStationHistory(cache), cache, 0)
+
+	args = flags.Args()
+	if len(args) == 0 {
+		print_aliases(matcher)
+		return
+	}
+
+	switch {
+	case args[0] == "set" && len(args) == 3:
+		id, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			log.Fatalln("invalid station id", args[2])
+		}
+		if err := matcher.SetAlias(args[1], id); err != nil {
+			log.Fatalln(err)
+		}
+	case args[0] == "approve" && len(args) == 2:
+		if !matcher.ApproveAlias(args[1]) {
+			log.Fatalln("no alias for", args[1])
+		}
+	case args[0] == "rm" && len(args) == 2:
+		matcher.RemoveAlias(args[1])
+	default:
+		stations_usage()
+	}
+}
+
+func stations_usage() {
+	fmt.Fprintf(os.Stderr, `Usage: bikage-cli stations alias [flags] [command]
+
+Commands:
+  (none)                 list the alias table
+  set <label> <id>       map a trip history label onto a station
+  approve <label>        mark a fuzzy match as reviewed
+  rm <label>             remove an alias
+`)
+	os.Exit(1)
+}
+
+func print_aliases(matcher *bikage.StationMatcher) {
+	now := time.Now()
+
+	for _, alias := range matcher.Aliases() {
+		station, _ := matcher.Resolve(alias.Label, now)
+
+		status := "unreviewed"
+		if alias.Reviewed {
+			status = "reviewed"
+		}
+
+		fmt.Printf("%s -> %s (%d), confidence %.2f, %s\n", alias.Label, station.Label, alias.StationId, alias.Confidence, status)
+	}
+}
+
+// print_unmatched reports the station labels that couldn't be matched
+func print_unmatched(matcher *bikage.StationMatcher) {
+	unmatched := matcher.Unmatched()
+	if len(unmatched) == 0 {
+		return
+	}
+
+	labels := make([]string, 0, len(unmatched))
+	for label := range unmatched {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	fmt.Fprintln(os.Stderr, "Unmatched stations, see bikage-cli stations alias:")
+	for _, label := range labels {
+		fmt.Fprintf(os.Stderr, "  %s (%d trips)\n", label, unmatched[label])
+	}
+}
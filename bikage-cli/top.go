@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func top_cmd(ctx context.Context, args []string) {
+	if len(args) == 0 || (args[0] != "stations" && args[0] != "routes") {
+		fmt.Fprintf(os.Stderr, "Usage: bikage-cli top stations|routes [flags]\n")
+		os.Exit(1)
+	}
+
+	var n int
+
+	flags := new_flag_set("top " + args[0])
+	params := add_query_flags(flags)
+	flags.IntVar(&n, "n", 10, "number of stations or routes listed, 0 for all")
+	parse_flags(flags, args[1:])
+
+	bk := new_bikage(ctx)
+	query := parse_query(params, bk.Location)
+
+	result := get_trips(ctx, bk)
+	print_unmatched(bk.Matcher)
+
+	trips := query.Apply(result.Trips)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if args[0] == "stations" {
+		fmt.Fprintln(w, "STATION\tTRIPS\tSTARTS\tENDS\tDISTANCE\tAVG DURATION")
+		for _, u := range bk.TopStations(ctx, trips, n) {
+			fmt.Fprintf(w, "%s (%d)\t%d\t%d\t%d\t%.1f km\t%s\n",
+				u.Station, u.Station.Id, u.Trips, u.Starts, u.Ends, float64(u.Distance)/1000, u.AvgDuration.Round(time.Second))
+		}
+	} else {
+		fmt.Fprintln(w, "ROUTE\tTRIPS\tRETURNS\tDISTANCE\tAVG DURATION")
+		for _, u := range bk.TopRoutes(ctx, trips, n) {
+			route := u.Route.String()
+			if u.RoundTrip {
+				route = u.Route.From.String() + " (round trip)"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%.1f km\t%s\n",
+				route, u.Trips, u.Returns, float64(u.Distance)/1000, u.AvgDuration.Round(time.Second))
+		}
+	}
+	w.Flush()
+}
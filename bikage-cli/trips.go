@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Bowbaq/bikage"
+)
+
+func trips_cmd(ctx context.Context, args []string) {
+	if len(args) == 0 {
+		trips_usage()
+	}
+
+	switch args[0] {
+	case "export":
+		trips_export_cmd(args[1:])
+	case "import":
+		trips_import_cmd(args[1:])
+	case "list":
+		trips_list_cmd(args[1:])
+	default:
+		trips_usage()
+	}
+}
+
+func trips_usage() {
+	fmt.Fprintf(os.Stderr, "Usage: bikage-cli trips list|export|import [flags]\n")
+	os.Exit(1)
+}
+
+// new_cache_flag_set returns a flag set for the commands that only work with
+// the cache, no login needed
+func new_cache_flag_set(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+
+	flags.StringVar(&username, "u", "", "member portal username (required)")
+	flags.StringVar(&mongo_url, "mongo-url", "", "MongoDB url (optional, defaults to local JSON cache)")
+	flags.StringVar(&system, "system", bikage.DefaultSystem, "bike share system, one of "+strings.Join(bikage.SystemIds(), ", "))
+
+	return flags
+}
+
+func new_cache() bikage.Cache {
+	sys, err := bikage.GetSystem(system)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	return bikage.NewCache(mongo_url, sys)
+}
+
+// query_usage documents the flags selecting trips, named after
+// bikage.TripQueryParams
+var query_usage = map[string]string{
+	"from":         "first day, yyyy-mm-dd",
+	"to":           "last day, yyyy-mm-dd",
+	"start":        "id of the start station",
+	"end":          "id of the end station",
+	"min_duration": "minimum trip duration, e.g. 10m",
+	"max_duration": "maximum trip duration, e.g. 1h",
+	"weekdays":     "comma separated days the trips started on, e.g. sat,sun",
+	"after":        "trips started at or after this time of day, hh:mm",
+	"before":       "trips started before this time of day, hh:mm",
+	"offset":       "number of trips skipped",
+	"limit":        "maximum number of trips",
+}
+
+// add_query_flags registers the flags selecting trips
+func add_query_flags(flags *flag.FlagSet) map[string]*string {
+	params := make(map[string]*string)
+	for _, name := range bikage.TripQueryParams {
+		params[name] = flags.String(strings.Replace(name, "_", "-", -1), "", query_usage[name]+" (optional)")
+	}
+
+	return params
+}
+
+// parse_query exits if a flag selecting trips is invalid
+func parse_query(params map[string]*string, location *time.Location) bikage.TripQuery {
+	values := make(url.Values)
+	for name, value := range params {
+		if *value != "" {
+			values.Set(name, *value)
+		}
+	}
+
+	query, err := bikage.ParseTripQuery(values, location)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	return query
+}
+
+func trips_list_cmd(args []string) {
+	flags := new_cache_flag_set("trips list")
+	flags.StringVar(&time_zone, "tz", "", "time zone of the days and times of day, e.g. Europe/Paris (optional, defaults to the system zone)")
+	params := add_query_flags(flags)
+	flags.Parse(args)
+
+	if username == "" {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	location := cache_location()
+	trips := new_cache().QueryTrips(username, parse_query(params, location))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tDURATION\tFROM\tTO")
+	for _, trip := range trips {
+		fmt.Fprintf(w, "%s\t%s\t%s (%d)\t%s (%d)\n",
+			trip.StartedAt.In(location).Format("2006-01-02 15:04"), trip.Duration(),
+			trip.Route.From, trip.Route.From.Id, trip.Route.To, trip.Route.To.Id)
+	}
+	w.Flush()
+
+	log.Println("Listed", len(trips), "trips")
+}
+
+// cache_location returns the zone set with -tz, or the zone of the system
+func cache_location() *time.Location {
+	if time_zone != "" {
+		location, err := time.LoadLocation(time_zone)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return location
+	}
+
+	sys, err := bikage.GetSystem(system)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	return sys.Location()
+}
+
+func trips_export_cmd(args []string) {
+	var format, output string
+
+	flags := new_cache_flag_set("trips export")
+	flags.StringVar(&format, "format", "", "csv or jsonl (optional, defaults to the output extension, then csv)")
+	flags.StringVar(&output, "o", "", "output file (optional, defaults to stdout)")
+	flags.Parse(args)
+
+	format = records_format(format, output)
+	if username == "" || format == "" {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	records := bikage.ExportTrips(new_cache(), username)
+
+	write_output(output, func(w io.Writer) error {
+		if format == "jsonl" {
+			return bikage.WriteTripsJSONL(w, records)
+		}
+		return bikage.WriteTripsCSV(w, records)
+	})
+
+	log.Println("Exported", len(records), "trips")
+}
+
+func trips_import_cmd(args []string) {
+	var format, input string
+
+	flags := new_cache_flag_set("trips import")
+	flags.StringVar(&format, "format", "", "csv or jsonl (optional, defaults to the input extension, then csv)")
+	flags.StringVar(&input, "i", "", "input file (optional, defaults to stdin)")
+	flags.Parse(args)
+
+	format = records_format(format, input)
+	if username == "" || format == "" {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	var r io.Reader = os.Stdin
+	if input != "" {
+		f, err := os.Open(input)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var records []bikage.TripRecord
+	var err error
+	if format == "jsonl" {
+		records, err = bikage.ReadTripsJSONL(r)
+	} else {
+		records, err = bikage.ReadTripsCSV(r)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	bikage.ImportTrips(new_cache(), username, records)
+
+	log.Println("Imported", len(records), "trips")
+}
+
+// records_format validates the format, guessing it from the file name if
+// needed. Returns "" for unknown formats.
+func records_format(format, filename string) string {
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(filename), ".")
+	}
+
+	switch format {
+	case "", "csv":
+		return "csv"
+	case "jsonl":
+		return "jsonl"
+	}
+
+	return ""
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
+	"crypto/sha1"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/Bowbaq/bikage"
+	"github.com/Bowbaq/bikage/export"
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/binding"
 	"github.com/martini-contrib/render"
@@ -22,25 +27,100 @@ func main() {
 }
 
 type server struct {
-	bk      *bikage.Bikage
+	config bikage.Config
+
+	systems map[string]*bikage.Bikage
+	lock    sync.Mutex
+
 	refresh chan *refresh_job
 }
 
 type credentials struct {
 	Username string `binding:"required"`
 	Password string `binding:"required"`
+	// System is the bike share system id, defaults to bikage.DefaultSystem
+	System string
 }
 
 func new_server(env Env) *server {
-	bikage, err := bikage.NewBikage(env["GOOGLE_APIKEY"], env["MONGODB_URI"])
-	if err != nil {
+	s := &server{
+		config: bikage.Config{
+			GoogleAPIKey: env["GOOGLE_APIKEY"],
+			MongoURL:     env["MONGODB_URI"],
+			GBFSEndpoint: env["GBFS_URL"],
+
+			TripWorkers:   uint(env.int("TRIP_WORKERS")),
+			TripRate:      env.int("TRIP_RATE"),
+			TripBurst:     env.int("TRIP_BURST"),
+			TripUserLimit: env.int("TRIP_USER_LIMIT"),
+		},
+		systems: make(map[string]*bikage.Bikage),
+		refresh: make(chan *refresh_job, 10),
+	}
+
+	// Fail early if the default system can't be served
+	if _, err := s.bikage(context.Background(), bikage.DefaultSystem); err != nil {
 		panic(err)
 	}
 
-	return &server{
-		bk:      bikage,
-		refresh: make(chan *refresh_job, 10),
+	return s
+}
+
+// bikage returns the instance serving the system, it is created on first use.
+// GBFS_URL only overrides the station feed of the default system.
+func (s *server) bikage(ctx context.Context, system string) (*bikage.Bikage, error) {
+	if system == "" {
+		system = bikage.DefaultSystem
+	}
+
+	s.lock.Lock()
+	bk, ok := s.systems[system]
+	s.lock.Unlock()
+
+	if ok {
+		return bk, nil
+	}
+
+	config := s.config
+	config.System = system
+	if system != bikage.DefaultSystem {
+		config.GBFSEndpoint = ""
+	}
+
+	// NewBikage loads the stations, the other systems are served meanwhile
+	bk, err := bikage.NewBikage(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Keep the instance of a concurrent request, if it was first
+	if existing, ok := s.systems[system]; ok {
+		return existing, nil
 	}
+	s.systems[system] = bk
+
+	return bk, nil
+}
+
+// system_handler maps the Bikage instance serving the system requested in the
+// credentials
+func (s *server) system_handler(c martini.Context, req *http.Request, r render.Render, creds credentials) {
+	if _, err := bikage.GetSystem(creds.System); err != nil {
+		r.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	bk, err := s.bikage(req.Context(), creds.System)
+	if err != nil {
+		log.Println("Server SYSTEM error ->", err)
+		r.JSON(502, map[string]string{"error": "couldn't load the " + creds.System + " system"})
+		return
+	}
+
+	c.Map(bk)
 }
 
 func (s *server) Run(env Env) {
@@ -56,8 +136,11 @@ func (s *server) Run(env Env) {
 
 	m.Get("/", s.IndexHandler)
 
-	m.Post("/api/trips", binding.Json(credentials{}), s.TripsAPI)
-	m.Post("/api/stats", binding.Json(credentials{}), s.StatsAPI)
+	m.Post("/api/trips", binding.Json(credentials{}), s.system_handler, s.TripsAPI)
+	m.Post("/api/stats", binding.Json(credentials{}), s.system_handler, s.StatsAPI)
+	m.Post("/api/achievements", binding.Json(credentials{}), s.system_handler, s.AchievementsAPI)
+	m.Post("/api/top", binding.Json(credentials{}), s.system_handler, s.TopAPI)
+	m.Post("/api/export", binding.Json(credentials{}), s.system_handler, s.ExportAPI)
 
 	m.Run()
 }
@@ -66,31 +149,71 @@ func (s *server) IndexHandler(r render.Render) {
 	r.HTML(200, "home", nil)
 }
 
-func (s *server) StatsAPI(req *http.Request, r render.Render, creds credentials) {
-	job := new_refresh_job(creds)
+// trip_query reads the trips selected by the query parameters, see
+// bikage.ParseTripQuery. Days are computed in the zone of the browser when
+// given, e.g. ?tz=Europe/Paris, in the zone of the system otherwise.
+func trip_query(req *http.Request, bk *bikage.Bikage) (*bikage.Bikage, bikage.TripQuery, error) {
+	params := req.URL.Query()
+
+	if tz := params.Get("tz"); tz != "" {
+		location, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, bikage.TripQuery{}, errors.New("unknown time zone " + tz)
+		}
+		bk = bk.In(location)
+	}
+
+	query, err := bikage.ParseTripQuery(params, bk.Location)
+
+	return bk, query, err
+}
+
+func (s *server) StatsAPI(req *http.Request, r render.Render, bk *bikage.Bikage, creds credentials) {
+	bk, query, err := trip_query(req, bk)
+	if err != nil {
+		r.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	job := new_refresh_job(bk, creds)
 	s.refresh <- job
 
 	if req.URL.Query().Get("cached") == "" {
-		<-job.done
+		if result := <-job.done; result.err != nil {
+			refresh_error(r, result.err)
+			return
+		}
 	}
 
-	stats := s.bk.ComputeStats(s.bk.GetCachedTrips(creds.Username))
+	stats := bk.ComputeStats(req.Context(), bk.QueryCachedTrips(creds.Username, query))
+
+	// ?compare=month compares the period with an earlier one, see
+	// bikage.Comparison
+	var comparison *bikage.StatsComparison
+	if compare := req.URL.Query().Get("compare"); compare != "" {
+		comparison, err = compare_stats(req, bk, creds, query, bikage.Comparison(compare))
+		if err != nil {
+			r.JSON(400, map[string]string{"error": err.Error()})
+			return
+		}
+	}
 
-	one_month_ago := time.Now().AddDate(0, 0, -30).Truncate(24 * time.Hour)
+	// The charts show the 30 days up to the end of the period
+	today := bk.StartOfDay(time.Now())
+	if !query.To.IsZero() {
+		today = bk.StartOfDay(query.To.AddDate(0, 0, -1))
+	}
 	last_month_dists := make([]float64, 0)
 	last_month_speeds := make([]float64, 0)
 	last_month_days := make([]string, 0)
 
-	for day := one_month_ago; day.Before(time.Now()); day = day.AddDate(0, 0, 1) {
+	for day := today.AddDate(0, 0, -30); !day.After(today); day = day.AddDate(0, 0, 1) {
 		last_month_days = append(last_month_days, day.Format("Jan 02"))
-		if daily_dist, ok := stats.DailyDistanceTotal[day.Format("01/02/2006 EST")]; ok {
-			last_month_dists = append(last_month_dists, float64(daily_dist)/1000)
+		if daily, ok := stats.Daily.Get(bk.Date(day)); ok {
+			last_month_dists = append(last_month_dists, daily.Km())
+			last_month_speeds = append(last_month_speeds, daily.Speed())
 		} else {
 			last_month_dists = append(last_month_dists, 0)
-		}
-		if daily_speed, ok := stats.DailySpeedTotal[day.Format("01/02/2006 EST")]; ok {
-			last_month_speeds = append(last_month_speeds, float64(daily_speed)/1000)
-		} else {
 			last_month_speeds = append(last_month_speeds, 0)
 		}
 	}
@@ -98,42 +221,215 @@ func (s *server) StatsAPI(req *http.Request, r render.Render, creds credentials)
 	data := struct {
 		Distance       string
 		Speed          string
-		DailyDistances []float64
-		DailySpeeds    []float64
+		Speeds         bikage.SpeedDistribution // km/h
+		DailyDistances []float64                // km
+		DailySpeeds    []float64                // km/h
 		Days           []string
+		Comparison     *bikage.StatsComparison
 	}{
 		Distance:       fmt.Sprintf("%.1f km (%.1f mi)", stats.TotalKm(), stats.TotalMi()),
 		Speed:          fmt.Sprintf("%.1f km/h (%.1f mph)", stats.AvgSpeed, stats.AvgSpeed/1.60934),
+		Speeds:         stats.Speeds,
 		DailyDistances: last_month_dists,
 		DailySpeeds:    last_month_speeds,
 		Days:           last_month_days,
+		Comparison:     comparison,
+	}
+
+	r.JSON(200, data)
+}
+
+// compare_stats compares the periods around the from and to parameters, the
+// other parameters select the trips of both periods
+func compare_stats(req *http.Request, bk *bikage.Bikage, creds credentials, query bikage.TripQuery, compare bikage.Comparison) (*bikage.StatsComparison, error) {
+	params := req.URL.Query()
+
+	r, err := bikage.ParseDateRange(params.Get("from"), params.Get("to"))
+	if err != nil {
+		return nil, err
+	}
+	query.From, query.To = time.Time{}, time.Time{}
+
+	return bk.CompareStats(req.Context(), bk.QueryCachedTrips(creds.Username, query), compare, r)
+}
+
+// AchievementsAPI returns the streaks, records, milestones and stations first
+// visited, the trips can be selected like for StatsAPI
+func (s *server) AchievementsAPI(req *http.Request, r render.Render, bk *bikage.Bikage, creds credentials) {
+	bk, query, err := trip_query(req, bk)
+	if err != nil {
+		r.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	job := new_refresh_job(bk, creds)
+	s.refresh <- job
+
+	if req.URL.Query().Get("cached") == "" {
+		if result := <-job.done; result.err != nil {
+			refresh_error(r, result.err)
+			return
+		}
+	}
+
+	r.JSON(200, bk.ComputeAchievements(req.Context(), bk.QueryCachedTrips(creds.Username, query)))
+}
+
+// TopAPI returns the most used stations and routes, ?n=20 sets how many (10 by
+// default, 0 for all), the trips can be selected like for StatsAPI
+func (s *server) TopAPI(req *http.Request, r render.Render, bk *bikage.Bikage, creds credentials) {
+	bk, query, err := trip_query(req, bk)
+	if err != nil {
+		r.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	n := 10
+	if value := req.URL.Query().Get("n"); value != "" {
+		if n, err = strconv.Atoi(value); err != nil {
+			r.JSON(400, map[string]string{"error": "invalid n " + value})
+			return
+		}
+	}
+
+	job := new_refresh_job(bk, creds)
+	s.refresh <- job
+
+	if req.URL.Query().Get("cached") == "" {
+		if result := <-job.done; result.err != nil {
+			refresh_error(r, result.err)
+			return
+		}
+	}
+
+	trips := bk.QueryCachedTrips(creds.Username, query)
+
+	data := struct {
+		Stations []bikage.StationUsage
+		Routes   []bikage.RouteUsage
+	}{
+		Stations: bk.TopStations(req.Context(), trips, n),
+		Routes:   bk.TopRoutes(req.Context(), trips, n),
+	}
+
+	r.JSON(200, data)
+}
+
+func (s *server) TripsAPI(req *http.Request, r render.Render, bk *bikage.Bikage, creds credentials) {
+	bk, query, err := trip_query(req, bk)
+	if err != nil {
+		r.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	job := new_refresh_job(bk, creds)
+	s.refresh <- job
+
+	result := <-job.done
+	if result.err != nil {
+		refresh_error(r, result.err)
+		return
+	}
+
+	data := struct {
+		Trips   []bikage.RoutedTrip
+		Skipped bikage.SkippedTrips
+	}{
+		Trips:   bk.RouteTrips(req.Context(), bk.QueryCachedTrips(creds.Username, query)),
+		Skipped: result.skipped,
 	}
 
 	r.JSON(200, data)
 }
 
-func (s *server) TripsAPI(r render.Render, creds credentials) {
-	job := new_refresh_job(creds)
+// ExportAPI sends the trips as a file download, the format is selected by the
+// format query parameter (gpx, kml or geojson)
+func (s *server) ExportAPI(w http.ResponseWriter, req *http.Request, bk *bikage.Bikage, creds credentials) {
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = export.GPX
+	}
+
+	content_type := export.ContentType(format)
+	if content_type == "" {
+		http.Error(w, "unknown export format "+format, http.StatusBadRequest)
+		return
+	}
+
+	job := new_refresh_job(bk, creds)
 	s.refresh <- job
-	<-job.done
 
-	r.JSON(200, s.bk.GetCachedTrips(creds.Username))
+	if result := <-job.done; result.err != nil {
+		status, message := refresh_status(result.err)
+		http.Error(w, message, status)
+		return
+	}
+
+	trips := bk.RouteTrips(req.Context(), bk.GetCachedTrips(creds.Username))
+
+	w.Header().Set("Content-Type", content_type)
+	w.Header().Set("Content-Disposition", "attachment; filename=\"bikage."+format+"\"")
+	if err := export.Write(w, format, trips); err != nil {
+		log.Println("ExportAPI WRITE error ->", err)
+	}
 }
 
+// refresh_job is signaled with the outcome of the last refresh
 type refresh_job struct {
+	bk    *bikage.Bikage
 	creds credentials
-	done  chan bool
+	done  chan refresh_result
+}
+
+type refresh_result struct {
+	skipped bikage.SkippedTrips
+	err     error
+}
+
+// refresh_status maps refresh errors to a status and a message: 401 when the
+// member portal rejects the credentials, 502 when it can't be read
+func refresh_status(err error) (int, string) {
+	switch {
+	case errors.Is(err, bikage.ErrInvalidCredentials):
+		return http.StatusUnauthorized, "invalid username or password"
+	case errors.Is(err, bikage.ErrRateLimited):
+		return http.StatusBadGateway, "the member portal is rate limiting requests, try again later"
+	case errors.Is(err, bikage.ErrCSRFNotFound), errors.Is(err, bikage.ErrLayoutChanged):
+		return http.StatusBadGateway, "the member portal changed and can't be read anymore"
+	}
+
+	return http.StatusBadGateway, "couldn't reach the member portal"
+}
+
+func refresh_error(r render.Render, err error) {
+	status, message := refresh_status(err)
+	r.JSON(status, map[string]string{"error": message})
+}
+
+// key identifies the user across systems, along with the password so that
+// the result of a refresh isn't returned for other credentials
+func (job *refresh_job) key() string {
+	return fmt.Sprintf("%s/%x", job, sha1.Sum([]byte(job.creds.Password)))
+}
+
+func (job *refresh_job) String() string {
+	return job.bk.System.Id + "/" + job.creds.Username
 }
 
 type job_descriptor struct {
 	last_run time.Time
 	requests []*refresh_job
+	result   refresh_result
 }
 
 const job_refresh_interval = 15 * time.Minute
 
-func new_refresh_job(creds credentials) *refresh_job {
-	return &refresh_job{creds, make(chan bool, 1)}
+// job_refresh_timeout bounds a refresh, which outlives the requests waiting
+// for it
+const job_refresh_timeout = 5 * time.Minute
+
+func new_refresh_job(bk *bikage.Bikage, creds credentials) *refresh_job {
+	return &refresh_job{bk, creds, make(chan refresh_result, 1)}
 }
 
 func (s *server) refresh_trips() {
@@ -142,39 +438,52 @@ func (s *server) refresh_trips() {
 
 	for job := range s.refresh {
 		lock.Lock()
-		descriptor, exists := jobs[job.creds.Username]
+		descriptor, exists := jobs[job.key()]
 
 		// return immediately if recently refreshed and not running
 		if exists && len(descriptor.requests) == 0 && time.Since(descriptor.last_run) < job_refresh_interval {
-			log.Println("Refresh ran recently for", job.creds.Username)
-			job.done <- true
+			log.Println("Refresh ran recently for", job)
+			job.done <- descriptor.result
 			lock.Unlock()
 			continue
 		}
 
 		// job is currently running, add request to the list, will be signaled on completion
 		if exists && len(descriptor.requests) > 0 {
-			log.Println("Queuing signal for", job.creds.Username)
+			log.Println("Queuing signal for", job)
 			descriptor.requests = append(descriptor.requests, job)
 			lock.Unlock()
 			continue
 		}
 
 		// job needs to be run
-		jobs[job.creds.Username] = &job_descriptor{
+		jobs[job.key()] = &job_descriptor{
 			last_run: time.Now(),
 			requests: []*refresh_job{job},
 		}
 		lock.Unlock()
 
 		go func() {
-			log.Println("Refreshing trips for", job.creds.Username)
-			s.bk.GetTrips(job.creds.Username, job.creds.Password)
+			log.Println("Refreshing trips for", job)
+			ctx, cancel := context.WithTimeout(context.Background(), job_refresh_timeout)
+			result, err := job.bk.GetTrips(ctx, job.creds.Username, job.creds.Password)
+			cancel()
+			if err != nil {
+				log.Println("Refresh GET error ->", err)
+			}
+			for _, page := range result.Failed {
+				log.Println("Refresh PAGE error ->", job, page)
+			}
 
 			lock.Lock()
-			adescriptor := jobs[job.creds.Username]
+			adescriptor := jobs[job.key()]
+			adescriptor.result = refresh_result{result.Skipped, err}
+			// Failed refreshes are retried by the next request
+			if err != nil {
+				adescriptor.last_run = time.Time{}
+			}
 			for _, req := range adescriptor.requests {
-				req.done <- true
+				req.done <- adescriptor.result
 			}
 			adescriptor.requests = []*refresh_job{}
 			lock.Unlock()
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"log"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/go-martini/martini"
@@ -24,6 +26,16 @@ func get_env() Env {
 	return env
 }
 
+// int returns the variable as an integer, 0 if it is unset or invalid
+func (env Env) int(name string) int {
+	value, err := strconv.Atoi(env[name])
+	if err != nil && env[name] != "" {
+		log.Println("Env", name, "error ->", err)
+	}
+
+	return value
+}
+
 func secure_handler() martini.Handler {
 	return secure.Secure(secure.Options{
 		AllowedHosts:         []string{"bikage.herokuapp.com"},
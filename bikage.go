@@ -1,10 +1,10 @@
 package bikage
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
-	"sort"
 	"strings"
 	"time"
 
@@ -12,46 +12,242 @@ import (
 )
 
 type Bikage struct {
+	System   System
+	Stations *StationHistory
+	Matcher  *StationMatcher
 	RouteAPI RouteAPI
 	TripAPI  TripAPI
+
+	// Location is the time zone trips are bucketed into days in, defaults to
+	// the zone of the system
+	Location *time.Location
 }
 
-const DayFormat = "01/02/2006 EST"
+// DayFormat is the format of the day keys of Stats.DailyDistanceTotal and
+// Stats.DailySpeedTotal
+const DayFormat = "01/02/2006"
+
+// Routers available to compute trip distances
+const (
+	RouterGoogle   = "google"   // Google Directions API, requires an API key
+	RouterEstimate = "estimate" // Offline haversine estimate, see NewEstimateRouteAPI
+	RouterOSM      = "osm"      // Offline routing over an OpenStreetMap graph, see NewOSMDirectionsAPI
+)
+
+type Config struct {
+	GoogleAPIKey string
+	MongoURL     string
+
+	// System is the id of the bike share system, defaults to DefaultSystem
+	System string
+	// GBFSEndpoint is the station feed auto-discovery url or file, defaults to
+	// the feed of the system
+	GBFSEndpoint string
+
+	// Router selects how distances are computed, defaults to RouterGoogle
+	Router string
+	// DetourFactor is only used by RouterEstimate, defaults to DefaultDetourFactor
+	DetourFactor float64
+	// OSMGraphPath is required by RouterOSM
+	OSMGraphPath string
+
+	// MatchThreshold is the similarity above which unknown station labels are
+	// matched automatically, defaults to DefaultMatchThreshold
+	MatchThreshold float64
+
+	// FullSync downloads the whole trip history instead of the pages that
+	// aren't cached yet
+	FullSync bool
+
+	// TripWorkers, TripRate and TripBurst configure the pool fetching the trip
+	// history pages, default to DefaultTripWorkers, DefaultTripRate and
+	// DefaultTripBurst. TripUserLimit caps the pages fetched at once for a
+	// single user, 0 means no limit.
+	TripWorkers   uint
+	TripRate      int
+	TripBurst     int
+	TripUserLimit int
+
+	// TimeZone is the IANA zone days are computed in, e.g. Europe/Paris,
+	// defaults to the zone of the system
+	TimeZone string
 
-func NewBikage(google_api_key string, mongo_url string) (*Bikage, error) {
-	stations, err := GetStations()
+	// TripDataPaths replaces the member trip history with the system data files
+	// published by the system, see NewSystemDataTripAPI
+	TripDataPaths []string
+}
+
+// NewBikage loads the stations of the system, ctx only bounds this first
+// request
+func NewBikage(ctx context.Context, config Config) (*Bikage, error) {
+	system, err := GetSystem(config.System)
+	if err != nil {
+		return nil, errors.New("Bikage SYSTEM error -> " + err.Error())
+	}
+
+	gbfs_endpoint := config.GBFSEndpoint
+	if gbfs_endpoint == "" {
+		gbfs_endpoint = system.GBFS
+	}
+
+	location := system.Location()
+	if config.TimeZone != "" {
+		if location, err = time.LoadLocation(config.TimeZone); err != nil {
+			return nil, errors.New("Bikage TIME ZONE error -> " + err.Error())
+		}
+	}
+
+	stations, err := NewGBFSClient(gbfs_endpoint).GetStations(ctx)
 	if err != nil {
 		return nil, errors.New("Bikage STATIONS GET error -> " + err.Error())
 	}
 
-	var cache Cache
-	cache, err = NewMongoCache(mongo_url)
+	cache := NewCache(config.MongoURL, system)
+
+	route_api, err := new_route_api(config)
 	if err != nil {
-		cache = NewJsonCache()
+		return nil, err
+	}
+
+	history := NewStationHistory(cache)
+	history.Observe(stations, time.Now())
+
+	matcher := NewStationMatcher(history, cache, config.MatchThreshold)
+
+	var trip_options []TripAPIOption
+	if config.FullSync {
+		trip_options = append(trip_options, WithFullSync())
+	}
+	if config.TripWorkers > 0 {
+		trip_options = append(trip_options, WithWorkers(config.TripWorkers))
+	}
+	if config.TripRate > 0 {
+		trip_options = append(trip_options, WithRateLimit(config.TripRate))
+	}
+	if config.TripBurst > 0 {
+		trip_options = append(trip_options, WithBurst(config.TripBurst))
+	}
+	if config.TripUserLimit > 0 {
+		trip_options = append(trip_options, WithUserLimit(config.TripUserLimit))
 	}
 
-	directions_api := distance.NewDirectionsAPI(google_api_key)
+	trip_api := NewTripAPI(system, matcher, trip_options...)
+	if len(config.TripDataPaths) > 0 {
+		trip_api = NewSystemDataTripAPI(stations, system.Location(), config.TripDataPaths...)
+	}
 
 	bikage := Bikage{
-		RouteAPI: NewRouteAPI(directions_api).WithCache(cache),
-		TripAPI:  NewTripAPI(stations).WithCache(cache),
+		System:   system,
+		Stations: history,
+		Matcher:  matcher,
+		RouteAPI: route_api.WithCache(cache),
+		TripAPI:  trip_api.WithCache(cache),
+		Location: location,
 	}
 
 	return &bikage, nil
 }
 
-func (bk *Bikage) GetTrips(username, password string) (Trips, error) {
-	return bk.TripAPI.GetTrips(username, password)
+// NewCache connects to MongoDB, falling back to the local JSON cache when
+// mongo_url is empty or the database can't be reached. Each system gets its own
+// collections, or its own file.
+func NewCache(mongo_url string, system System) Cache {
+	namespace := system.cache_namespace()
+
+	cache, err := NewPrefixedMongoCache(mongo_url, namespace)
+	if err != nil {
+		if namespace == "" {
+			return NewJsonCache()
+		}
+		return NewJsonCacheAt(fmt.Sprintf("./bikage_%s_cache.json", namespace))
+	}
+
+	return cache
+}
+
+func new_route_api(config Config) (RouteAPI, error) {
+	switch config.Router {
+	case "", RouterGoogle:
+		if config.GoogleAPIKey == "" {
+			return nil, errors.New("Bikage ROUTER error -> google router requires an API key")
+		}
+		return NewRouteAPI(distance.NewDirectionsAPI(config.GoogleAPIKey)), nil
+	case RouterEstimate:
+		return NewEstimateRouteAPI(config.DetourFactor), nil
+	case RouterOSM:
+		if config.OSMGraphPath == "" {
+			return nil, errors.New("Bikage ROUTER error -> osm router requires a graph file")
+		}
+		directions_api, err := NewOSMDirectionsAPI(config.OSMGraphPath)
+		if err != nil {
+			return nil, errors.New("Bikage OSM GRAPH LOAD error -> " + err.Error())
+		}
+		return NewRouteAPI(directions_api), nil
+	}
+
+	return nil, fmt.Errorf("Bikage ROUTER error -> unknown router %q", config.Router)
+}
+
+func (bk *Bikage) GetTrips(ctx context.Context, username, password string) (TripsResult, error) {
+	return bk.TripAPI.GetTrips(ctx, username, password)
 }
 
 func (bk *Bikage) GetCachedTrips(username string) Trips {
 	return bk.TripAPI.GetCachedTrips(username)
 }
 
-func (bk *Bikage) ComputeStats(trips Trips) *Stats {
-	distances := bk.RouteAPI.GetAll(trips)
+// QueryCachedTrips returns the cached trips selected by the query, weekdays and
+// times of day default to the zone of the user
+func (bk *Bikage) QueryCachedTrips(username string, query TripQuery) Trips {
+	if query.Location == nil {
+		query.Location = bk.location()
+	}
+
+	return bk.TripAPI.QueryCachedTrips(username, query)
+}
+
+// RouteTrips returns the trips along with the distance and geometry of their
+// routes. Trips that can't be routed have a zero distance and a straight path.
+func (bk *Bikage) RouteTrips(ctx context.Context, trips Trips) []RoutedTrip {
+	return bk.RouteAPI.GetAllRoutes(ctx, trips)
+}
+
+// location returns the zone days are computed in
+func (bk *Bikage) location() *time.Location {
+	if bk.Location != nil {
+		return bk.Location
+	}
+
+	return bk.System.Location()
+}
+
+// In returns a copy of bk computing days in location
+func (bk *Bikage) In(location *time.Location) *Bikage {
+	in := *bk
+	in.Location = location
+
+	return &in
+}
+
+// Date returns the day of t in the zone of the user
+func (bk *Bikage) Date(t time.Time) Date {
+	return DateOf(t.In(bk.location()))
+}
+
+// StartOfDay returns midnight of the day of t in the zone of the user
+func (bk *Bikage) StartOfDay(t time.Time) time.Time {
+	t = t.In(bk.location())
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
 
+func (bk *Bikage) ComputeStats(ctx context.Context, trips Trips) *Stats {
+	return bk.compute_stats(trips, bk.RouteAPI.GetAll(ctx, trips))
+}
+
+func (bk *Bikage) compute_stats(trips Trips, distances map[Trip]uint64) *Stats {
 	stats := NewStats()
+	speeds := make([]float64, 0, len(trips))
+	outliers := 0
 	for _, trip := range trips {
 		dist, ok := distances[trip]
 		if !ok {
@@ -59,17 +255,12 @@ func (bk *Bikage) ComputeStats(trips Trips) *Stats {
 			continue
 		}
 
-		day := trip.StartedAt.Format(DayFormat)
-		if day_distance_total, ok := stats.DailyDistanceTotal[day]; ok {
-			stats.DailyDistanceTotal[day] = day_distance_total + dist
-		} else {
-			stats.DailyDistanceTotal[day] = dist
-		}
+		stats.Daily = stats.Daily.Add(bk.Date(trip.StartedAt), dist, trip.Duration())
 
-		if day_speed_total, ok := stats.DailySpeedTotal[day]; ok {
-			stats.DailySpeedTotal[day] = day_speed_total + float64(dist)/trip.Duration().Hours()
+		if speed, outlier := trip_speed(dist, trip.Duration()); outlier {
+			outliers++
 		} else {
-			stats.DailySpeedTotal[day] = float64(dist) / trip.Duration().Hours()
+			speeds = append(speeds, speed)
 		}
 
 		stats.Total += dist
@@ -77,21 +268,50 @@ func (bk *Bikage) ComputeStats(trips Trips) *Stats {
 		stats.TotalTime += trip.Duration()
 	}
 
-	stats.AvgSpeed = stats.TotalKm() / stats.TotalTime.Hours()
+	for _, day := range stats.Daily {
+		key := day.Start.Time(time.UTC).Format(DayFormat)
+		stats.DailyDistanceTotal[key] = day.Distance
+		stats.DailySpeedTotal[key] = day.Speed()
+	}
+
+	stats.AvgSpeed = stats.Daily.Total().Speed()
+	stats.Speeds = new_speed_distribution(speeds, outliers)
 
 	return stats
 }
 
 type Stats struct {
-	Total              uint64
-	TotalTime          time.Duration
+	Total     uint64
+	TotalTime time.Duration
+
+	// DailyDistanceTotal and DailySpeedTotal hold the distance in meters and
+	// the average speed in km/h of each day, keyed by DayFormat. They mirror
+	// Daily, kept for the existing callers.
 	DailyDistanceTotal map[string]uint64
 	DailySpeedTotal    map[string]float64
-	AvgSpeed           float64
+
+	// AvgSpeed is the average speed of the trips in km/h weighted by their
+	// distance, Speeds their distribution. Both leave out the outliers, see
+	// MinTripSpeed.
+	AvgSpeed float64
+	Speeds   SpeedDistribution
+
+	// Daily holds the totals of each day trips were taken, in the zone of the
+	// user. Use Daily.By to get weekly, monthly or yearly totals.
+	Daily Series
 }
 
 func NewStats() *Stats {
-	return &Stats{DailyDistanceTotal: make(map[string]uint64), DailySpeedTotal: make(map[string]float64)}
+	return &Stats{
+		DailyDistanceTotal: make(map[string]uint64),
+		DailySpeedTotal:    make(map[string]float64),
+		Daily:              make(Series, 0),
+	}
+}
+
+// Trips returns the number of trips the stats were computed over
+func (s *Stats) Trips() int {
+	return s.Daily.Total().Trips
 }
 
 func (s *Stats) TotalKm() float64 {
@@ -111,12 +331,14 @@ func mi_dist(dist uint64) float64 {
 }
 
 func (s Stats) String() string {
-	summaries := make([]string, 0)
-	for day, dist := range s.DailyDistanceTotal {
-		summary := fmt.Sprintf("  %s %.1f km (%.1f mi)", day, km_dist(dist), mi_dist(dist))
-		summaries = append(summaries, summary)
+	summaries := make([]string, 0, len(s.Daily))
+	for i := len(s.Daily) - 1; i >= 0; i-- {
+		day := s.Daily[i]
+		summaries = append(summaries, fmt.Sprintf("  %s %.1f km (%.1f mi)", day.Start, day.Km(), day.Mi()))
 	}
-	sort.Sort(sort.Reverse(sort.StringSlice(summaries)))
 
-	return fmt.Sprintf("Total:\n  %.1f km (%.1f mi)\nDetails:\n%s", s.TotalKm(), s.TotalMi(), strings.Join(summaries, "\n"))
+	speed := fmt.Sprintf("  average %.1f km/h, median %.1f km/h, 90th percentile %.1f km/h, max %.1f km/h (%d outliers left out)",
+		s.AvgSpeed, s.Speeds.Median, s.Speeds.P90, s.Speeds.Max, s.Speeds.Outliers)
+
+	return fmt.Sprintf("Total:\n  %.1f km (%.1f mi)\nSpeed:\n%s\nDetails:\n%s", s.TotalKm(), s.TotalMi(), speed, strings.Join(summaries, "\n"))
 }
@@ -1,6 +1,7 @@
 package bikage_test
 
 import (
+	"context"
 	"time"
 
 	. "github.com/Bowbaq/bikage"
@@ -17,12 +18,13 @@ var _ = Describe("bikage", func() {
 		bk := &Bikage{
 			RouteAPI: &route_api,
 			TripAPI:  &trip_api,
+			Location: time.Local,
 		}
 
 		var stats *Stats
 
 		Context("without any trips", func() {
-			stats = bk.ComputeStats(Trips{})
+			stats = bk.ComputeStats(context.Background(), Trips{})
 
 			It("returns 0 total miles biked", func() {
 				Expect(stats.Total).To(BeZero())
@@ -30,6 +32,7 @@ var _ = Describe("bikage", func() {
 
 			It("has no dailt totals", func() {
 				Expect(stats.DailyDistanceTotal).To(HaveLen(0))
+				Expect(stats.Daily).To(BeEmpty())
 			})
 		})
 
@@ -51,7 +54,7 @@ var _ = Describe("bikage", func() {
 					}
 				}
 
-				stats = bk.ComputeStats(trips)
+				stats = bk.ComputeStats(context.Background(), trips)
 			})
 
 			Describe("stats.Total", func() {
@@ -71,6 +74,84 @@ var _ = Describe("bikage", func() {
 				route_api.get_all = nil
 			})
 		})
+
+		Context("with trips near midnight", func() {
+			new_york, _ := time.LoadLocation("America/New_York")
+
+			// 23:30 in New York is already the next day in UTC
+			late := Trip{Id: "late", StartedAt: time.Date(2014, 11, 2, 23, 30, 0, 0, new_york)}
+			// the day after the switch to winter time, 05:30 UTC
+			early := Trip{Id: "early", StartedAt: time.Date(2014, 11, 3, 0, 30, 0, 0, new_york).UTC()}
+
+			BeforeEach(func() {
+				route_api.get_all = func(trips Trips) map[Trip]uint64 {
+					return map[Trip]uint64{late: 1000, early: 2000}
+				}
+			})
+
+			AfterEach(func() {
+				route_api.get_all = nil
+			})
+
+			It("buckets the trips into days in the zone of the user", func() {
+				stats := bk.In(new_york).ComputeStats(context.Background(), Trips{late, early})
+				Expect(stats.DailyDistanceTotal).To(Equal(map[string]uint64{"11/02/2014": 1000, "11/03/2014": 2000}))
+			})
+
+			It("buckets the trips into other days in another zone", func() {
+				stats := bk.In(time.UTC).ComputeStats(context.Background(), Trips{late, early})
+				Expect(stats.DailyDistanceTotal).To(Equal(map[string]uint64{"11/03/2014": 3000}))
+			})
+		})
+	})
+
+	Describe("StartOfDay()", func() {
+		It("returns midnight in the zone of the user across DST changes", func() {
+			new_york, _ := time.LoadLocation("America/New_York")
+			bk := (&Bikage{}).In(new_york)
+
+			day := bk.StartOfDay(time.Date(2014, 11, 2, 12, 0, 0, 0, time.UTC))
+			Expect(day).To(Equal(time.Date(2014, 11, 2, 0, 0, 0, 0, new_york)))
+			Expect(bk.Date(day.AddDate(0, 0, 1))).To(Equal(Date{2014, time.November, 3}))
+		})
+	})
+
+	Describe("ComputeStats() speeds", func() {
+		route_api := test_route_api{}
+		bk := &Bikage{RouteAPI: &route_api, TripAPI: &test_trip_api{}, Location: time.UTC}
+
+		start := time.Date(2014, 7, 2, 8, 0, 0, 0, time.UTC)
+		trip := func(id string, minutes int) Trip {
+			return Trip{Id: id, StartedAt: start, EndedAt: start.Add(time.Duration(minutes) * time.Minute)}
+		}
+
+		// 15, 20 and 10 km/h, and a bike kept for two hours
+		trips := Trips{trip("1", 12), trip("2", 6), trip("3", 6), trip("idle", 120)}
+
+		BeforeEach(func() {
+			route_api.get_all = func(trips Trips) map[Trip]uint64 {
+				return map[Trip]uint64{trips[0]: 3000, trips[1]: 2000, trips[2]: 1000, trips[3]: 1000}
+			}
+		})
+
+		It("averages the speeds instead of adding them up", func() {
+			stats := bk.ComputeStats(context.Background(), trips)
+
+			day, _ := stats.Daily.Get(Date{2014, time.July, 2})
+			Expect(day.Speed()).To(BeNumerically("~", 15.83, 0.01))
+			Expect(stats.DailySpeedTotal).To(HaveKeyWithValue("07/02/2014", BeNumerically("~", 15.83, 0.01)))
+			Expect(stats.AvgSpeed).To(BeNumerically("~", 15.83, 0.01))
+		})
+
+		It("computes the distribution of the speeds without the outliers", func() {
+			stats := bk.ComputeStats(context.Background(), trips)
+
+			Expect(stats.Speeds.Trips).To(Equal(3))
+			Expect(stats.Speeds.Outliers).To(Equal(1))
+			Expect(stats.Speeds.Median).To(BeNumerically("~", 15, 0.01))
+			Expect(stats.Speeds.P90).To(BeNumerically("~", 19, 0.01))
+			Expect(stats.Speeds.Max).To(BeNumerically("~", 20, 0.01))
+		})
 	})
 
 	Describe("Stats", func() {
@@ -95,9 +176,13 @@ type test_route_api struct {
 	get_all func(trips Trips) map[Trip]uint64
 }
 
-func (tra *test_route_api) WithCache(cache DistanceCache) RouteAPI { return tra }
-func (tra *test_route_api) Get(trip Trip) (uint64, error)          { return 0, nil }
-func (tra *test_route_api) GetAll(trips Trips) map[Trip]uint64 {
+func (tra *test_route_api) WithCache(cache DistanceCache) RouteAPI             { return tra }
+func (tra *test_route_api) Get(ctx context.Context, trip Trip) (uint64, error) { return 0, nil }
+func (tra *test_route_api) GetRoute(ctx context.Context, trip Trip) (RoutedTrip, error) {
+	return RoutedTrip{}, nil
+}
+func (tra *test_route_api) GetAllRoutes(ctx context.Context, trips Trips) []RoutedTrip { return nil }
+func (tra *test_route_api) GetAll(ctx context.Context, trips Trips) map[Trip]uint64 {
 	if tra.get_all != nil {
 		return tra.get_all(trips)
 	}
@@ -107,6 +192,11 @@ func (tra *test_route_api) GetAll(trips Trips) map[Trip]uint64 {
 
 type test_trip_api struct{}
 
-func (tta *test_trip_api) WithCache(cache TripCache) TripAPI                 { return tta }
-func (tta *test_trip_api) GetTrips(username, password string) (Trips, error) { return Trips{}, nil }
-func (tta *test_trip_api) GetCachedTrips(username string) Trips              { return Trips{} }
+func (tta *test_trip_api) WithCache(cache TripCache) TripAPI { return tta }
+func (tta *test_trip_api) GetTrips(ctx context.Context, username, password string) (TripsResult, error) {
+	return TripsResult{}, nil
+}
+func (tta *test_trip_api) GetCachedTrips(username string) Trips { return Trips{} }
+func (tta *test_trip_api) QueryCachedTrips(username string, query TripQuery) Trips {
+	return Trips{}
+}
@@ -3,15 +3,42 @@ package bikage
 type DistanceCache interface {
 	GetDistance(route Route) (uint64, bool)
 	PutDistance(route Route, distance uint64)
+
+	GetPath(route Route) (Path, bool)
+	PutPath(route Route, path Path)
 }
 
 type TripCache interface {
 	GetTrip(username, id string) (Trip, bool)
 	GetTrips(username string) Trips
 	PutTrip(username string, trip Trip)
+
+	// QueryTrips returns the trips of the user selected by the query
+	QueryTrips(username string, query TripQuery) Trips
+
+	// GetIncomplete tells whether the last sync of the user missed pages of the
+	// trip history, the next sync then reads the whole history again
+	GetIncomplete(username string) bool
+	PutIncomplete(username string, incomplete bool)
+}
+
+// StationCache persists the StationHistory, as every known version of each
+// station keyed by station id
+type StationCache interface {
+	GetStationHistory() map[uint64][]StationVersion
+	PutStationHistory(history map[uint64][]StationVersion)
+}
+
+// AliasCache persists the StationMatcher alias table, keyed by label
+type AliasCache interface {
+	GetAliases() []StationAlias
+	PutAlias(alias StationAlias)
+	DeleteAlias(label string)
 }
 
 type Cache interface {
 	DistanceCache
 	TripCache
+	StationCache
+	AliasCache
 }
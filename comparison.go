@@ -0,0 +1,257 @@
+package bikage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// DateRange holds the days from From to To, both included. A zero From or To
+// leaves the range open on that side.
+type DateRange struct {
+	From Date
+	To   Date
+}
+
+// ParseDateRange parses the bounds of a range written yyyy-mm-dd, empty bounds
+// are left open
+func ParseDateRange(from, to string) (DateRange, error) {
+	var r DateRange
+	var err error
+
+	if from != "" {
+		if r.From, err = ParseDate(from); err != nil {
+			return r, fmt.Errorf("invalid from: %v", err)
+		}
+	}
+	if to != "" {
+		if r.To, err = ParseDate(to); err != nil {
+			return r, fmt.Errorf("invalid to: %v", err)
+		}
+	}
+
+	return r, nil
+}
+
+func (r DateRange) Contains(day Date) bool {
+	return (r.From.IsZero() || !day.Before(r.From)) && (r.To.IsZero() || !r.To.Before(day))
+}
+
+// Query returns the query selecting the trips started during the range, in
+// location (UTC if nil)
+func (r DateRange) Query(location *time.Location) TripQuery {
+	query := TripQuery{Location: location}
+	if !r.From.IsZero() {
+		query.From = r.From.Time(query.location())
+	}
+	if !r.To.IsZero() {
+		query.To = r.To.AddDays(1).Time(query.location())
+	}
+
+	return query
+}
+
+func (r DateRange) String() string {
+	from, to := "...", "..."
+	if !r.From.IsZero() {
+		from = r.From.String()
+	}
+	if !r.To.IsZero() {
+		to = r.To.String()
+	}
+
+	return from + " to " + to
+}
+
+// Comparison selects the periods compared by CompareStats
+type Comparison string
+
+const (
+	CompareWeek     Comparison = "week"     // the week so far, against the same days of the week before
+	CompareMonth    Comparison = "month"    // the month so far, against the same days of the month before
+	CompareYTD      Comparison = "ytd"      // the year so far, against the same days of the year before
+	CompareYoY      Comparison = "yoy"      // the range, against the same days of the year before
+	ComparePrevious Comparison = "previous" // the range, against as many days right before it
+)
+
+var Comparisons = []Comparison{CompareWeek, CompareMonth, CompareYTD, CompareYoY, ComparePrevious}
+
+// Ranges returns the current and baseline ranges. The week, month and year so
+// far end on r.To, the other comparisons need both bounds of r.
+func (c Comparison) Ranges(r DateRange) (DateRange, DateRange, error) {
+	if !c.valid() {
+		return r, r, fmt.Errorf("unknown comparison %q, expected one of %s", string(c), comparison_names())
+	}
+	if r.To.IsZero() {
+		return r, r, fmt.Errorf("compare %s needs the last day of the range", c)
+	}
+
+	var current DateRange
+	switch c {
+	case CompareWeek:
+		current = DateRange{PeriodWeek.Start(r.To), r.To}
+		return current, DateRange{current.From.AddDays(-7), current.To.AddDays(-7)}, nil
+	case CompareMonth:
+		current = DateRange{PeriodMonth.Start(r.To), r.To}
+		return current, DateRange{add_months(current.From, -1), add_months(current.To, -1)}, nil
+	case CompareYTD:
+		current = DateRange{PeriodYear.Start(r.To), r.To}
+		return current, DateRange{add_months(current.From, -12), add_months(current.To, -12)}, nil
+	}
+
+	if r.From.IsZero() || r.To.Before(r.From) {
+		return r, r, fmt.Errorf("compare %s needs the first and last day of the range", c)
+	}
+
+	if c == CompareYoY {
+		return r, DateRange{add_months(r.From, -12), add_months(r.To, -12)}, nil
+	}
+
+	days := int(r.To.Time(time.UTC).Sub(r.From.Time(time.UTC)).Hours()/24) + 1
+	return r, DateRange{r.From.AddDays(-days), r.From.AddDays(-1)}, nil
+}
+
+func (c Comparison) valid() bool {
+	for _, comparison := range Comparisons {
+		if c == comparison {
+			return true
+		}
+	}
+
+	return false
+}
+
+func comparison_names() string {
+	names := make([]string, 0, len(Comparisons))
+	for _, c := range Comparisons {
+		names = append(names, string(c))
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// add_months moves the date by months, days past the end of the month are
+// moved to the last day of the month
+func add_months(d Date, months int) Date {
+	first := Date{d.Year, d.Month, 1}.Time(time.UTC).AddDate(0, months, 0)
+	last := DateOf(first.AddDate(0, 1, -1))
+	if d.Day > last.Day {
+		return last
+	}
+
+	return Date{first.Year(), first.Month(), d.Day}
+}
+
+// RangeStats are the stats of the trips started during Range
+type RangeStats struct {
+	Range DateRange
+	*Stats
+}
+
+// StatsComparison holds the stats of two periods, and how the current one
+// changed since the baseline
+type StatsComparison struct {
+	Comparison Comparison
+	Current    RangeStats
+	Baseline   RangeStats
+	Delta      StatsDelta
+}
+
+// StatsDelta holds the differences between the current and baseline stats.
+// The changes are relative, 0.1 for 10% more, nil when the baseline is zero.
+type StatsDelta struct {
+	Distance int64 // meters
+	Time     time.Duration
+	Trips    int
+	AvgSpeed float64 // km/h
+
+	DistanceChange *float64
+	TimeChange     *float64
+	TripsChange    *float64
+	AvgSpeedChange *float64
+}
+
+// ComputeRangeStats computes the stats of the trips started during the range,
+// in the zone of the user
+func (bk *Bikage) ComputeRangeStats(ctx context.Context, trips Trips, r DateRange) RangeStats {
+	return RangeStats{r, bk.ComputeStats(ctx, r.Query(bk.location()).Apply(trips))}
+}
+
+// CompareStats computes the stats of the trips over the periods of the
+// comparison, see Comparison.Ranges. r.To defaults to today.
+func (bk *Bikage) CompareStats(ctx context.Context, trips Trips, comparison Comparison, r DateRange) (*StatsComparison, error) {
+	if r.To.IsZero() {
+		r.To = bk.Date(time.Now())
+	}
+
+	current, baseline, err := comparison.Ranges(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &StatsComparison{
+		Comparison: comparison,
+		Current:    bk.ComputeRangeStats(ctx, trips, current),
+		Baseline:   bk.ComputeRangeStats(ctx, trips, baseline),
+	}
+	c.Delta = new_stats_delta(c.Current.Stats, c.Baseline.Stats)
+
+	return c, nil
+}
+
+func new_stats_delta(current, baseline *Stats) StatsDelta {
+	current_trips, baseline_trips := current.Trips(), baseline.Trips()
+
+	return StatsDelta{
+		Distance: int64(current.Total) - int64(baseline.Total),
+		Time:     current.TotalTime - baseline.TotalTime,
+		Trips:    current_trips - baseline_trips,
+		AvgSpeed: current.AvgSpeed - baseline.AvgSpeed,
+
+		DistanceChange: change(float64(current.Total), float64(baseline.Total)),
+		TimeChange:     change(float64(current.TotalTime), float64(baseline.TotalTime)),
+		TripsChange:    change(float64(current_trips), float64(baseline_trips)),
+		AvgSpeedChange: change(current.AvgSpeed, baseline.AvgSpeed),
+	}
+}
+
+func change(current, baseline float64) *float64 {
+	if baseline == 0 {
+		return nil
+	}
+
+	relative := (current - baseline) / baseline
+	return &relative
+}
+
+func (c StatsComparison) String() string {
+	var b strings.Builder
+
+	w := tabwriter.NewWriter(&b, 0, 4, 3, ' ', 0)
+	fmt.Fprintf(w, "\t%s\t%s\tchange\n", c.Current.Range, c.Baseline.Range)
+	fmt.Fprintf(w, "Distance\t%.1f km\t%.1f km\t%+.1f km%s\n",
+		c.Current.TotalKm(), c.Baseline.TotalKm(), km_dist_delta(c.Delta.Distance), percent(c.Delta.DistanceChange))
+	fmt.Fprintf(w, "Time\t%s\t%s\t%+.1f h%s\n",
+		c.Current.TotalTime, c.Baseline.TotalTime, c.Delta.Time.Hours(), percent(c.Delta.TimeChange))
+	fmt.Fprintf(w, "Trips\t%d\t%d\t%+d%s\n",
+		c.Current.Trips(), c.Baseline.Trips(), c.Delta.Trips, percent(c.Delta.TripsChange))
+	fmt.Fprintf(w, "Avg speed\t%.1f km/h\t%.1f km/h\t%+.1f km/h%s\n",
+		c.Current.AvgSpeed, c.Baseline.AvgSpeed, c.Delta.AvgSpeed, percent(c.Delta.AvgSpeedChange))
+	w.Flush()
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func km_dist_delta(dist int64) float64 {
+	return float64(dist) / 1000
+}
+
+func percent(change *float64) string {
+	if change == nil {
+		return ""
+	}
+
+	return fmt.Sprintf(" (%+.1f%%)", *change*100)
+}
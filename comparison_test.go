@@ -0,0 +1,103 @@
+package bikage_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/Bowbaq/bikage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Comparison", func() {
+	day := func(year int, month time.Month, day int) Date {
+		return Date{year, month, day}
+	}
+
+	Describe("Ranges()", func() {
+		// Thursday
+		r := DateRange{To: day(2024, time.March, 14)}
+
+		It("compares the week so far with the same days of the week before", func() {
+			current, baseline, err := CompareWeek.Ranges(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current).To(Equal(DateRange{day(2024, time.March, 11), day(2024, time.March, 14)}))
+			Expect(baseline).To(Equal(DateRange{day(2024, time.March, 4), day(2024, time.March, 7)}))
+		})
+
+		It("compares the month so far, up to the end of shorter months", func() {
+			_, baseline, _ := CompareMonth.Ranges(r)
+			Expect(baseline).To(Equal(DateRange{day(2024, time.February, 1), day(2024, time.February, 14)}))
+
+			_, baseline, _ = CompareMonth.Ranges(DateRange{To: day(2024, time.March, 31)})
+			Expect(baseline).To(Equal(DateRange{day(2024, time.February, 1), day(2024, time.February, 29)}))
+		})
+
+		It("compares the year so far and ranges with the year before", func() {
+			current, baseline, _ := CompareYTD.Ranges(r)
+			Expect(current.From).To(Equal(day(2024, time.January, 1)))
+			Expect(baseline).To(Equal(DateRange{day(2023, time.January, 1), day(2023, time.March, 14)}))
+
+			_, baseline, _ = CompareYoY.Ranges(DateRange{day(2024, time.February, 1), day(2024, time.February, 29)})
+			Expect(baseline).To(Equal(DateRange{day(2023, time.February, 1), day(2023, time.February, 28)}))
+		})
+
+		It("compares a range with as many days right before it", func() {
+			_, baseline, err := ComparePrevious.Ranges(DateRange{day(2024, time.March, 1), day(2024, time.March, 10)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(baseline).To(Equal(DateRange{day(2024, time.February, 20), day(2024, time.February, 29)}))
+		})
+
+		It("rejects unknown comparisons and incomplete ranges", func() {
+			_, _, err := Comparison("decade").Ranges(r)
+			Expect(err).To(MatchError(ContainSubstring("unknown comparison")))
+
+			_, _, err = CompareYoY.Ranges(r)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("CompareStats()", func() {
+		route_api := test_route_api{}
+		bk := &Bikage{RouteAPI: &route_api, TripAPI: &test_trip_api{}, Location: time.UTC}
+
+		trip := func(id string, started time.Time) Trip {
+			return Trip{Id: id, StartedAt: started, EndedAt: started.Add(12 * time.Minute)}
+		}
+
+		this_week := trip("this week", time.Date(2024, 3, 12, 8, 0, 0, 0, time.UTC))
+		last_week := trip("last week", time.Date(2024, 3, 5, 8, 0, 0, 0, time.UTC))
+		// after the day the week so far is compared with
+		late := trip("late", time.Date(2024, 3, 8, 8, 0, 0, 0, time.UTC))
+
+		BeforeEach(func() {
+			route_api.get_all = func(trips Trips) map[Trip]uint64 {
+				return map[Trip]uint64{this_week: 3000, last_week: 2000, late: 5000}
+			}
+		})
+
+		AfterEach(func() {
+			route_api.get_all = nil
+		})
+
+		It("computes the stats of both periods and the deltas", func() {
+			c, err := bk.CompareStats(context.Background(), Trips{this_week, last_week, late}, CompareWeek, DateRange{To: day(2024, time.March, 14)})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(c.Current.Total).To(BeNumerically("==", 3000))
+			Expect(c.Baseline.Total).To(BeNumerically("==", 2000))
+			Expect(c.Delta.Distance).To(BeNumerically("==", 1000))
+			Expect(c.Delta.Trips).To(BeZero())
+			Expect(*c.Delta.DistanceChange).To(BeNumerically("~", 0.5, 0.001))
+			Expect(*c.Delta.AvgSpeedChange).To(BeNumerically("~", 0.5, 0.001))
+		})
+
+		It("leaves out the relative changes from an empty baseline", func() {
+			c, err := bk.CompareStats(context.Background(), Trips{this_week}, CompareWeek, DateRange{To: day(2024, time.March, 14)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.Delta.Trips).To(Equal(1))
+			Expect(c.Delta.DistanceChange).To(BeNil())
+		})
+	})
+})
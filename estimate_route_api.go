@@ -0,0 +1,102 @@
+package bikage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+
+	"github.com/Bowbaq/distance"
+)
+
+// DefaultDetourFactor approximates how much longer a ride through the street
+// grid is than the straight line between two stations.
+const DefaultDetourFactor = 1.3
+
+const earth_radius = 6371008.8 // meters
+
+type estimate_route_api struct {
+	detour_factor float64
+}
+
+// NewEstimateRouteAPI returns a RouteAPI that never leaves the process: the
+// distance of a route is the haversine distance between its stations,
+// multiplied by detour_factor. A factor <= 0 selects DefaultDetourFactor.
+func NewEstimateRouteAPI(detour_factor float64) RouteAPI {
+	if detour_factor <= 0 {
+		detour_factor = DefaultDetourFactor
+	}
+
+	return &estimate_route_api{
+		detour_factor: detour_factor,
+	}
+}
+
+// WithCache is a no-op, estimates are cheap to compute and must not end up in
+// a cache shared with real routing results.
+func (ea *estimate_route_api) WithCache(cache DistanceCache) RouteAPI {
+	return ea
+}
+
+func (ea *estimate_route_api) Get(ctx context.Context, trip Trip) (uint64, error) {
+	from, to := trip.Route.From, trip.Route.To
+	if !from.HasCoord() || !to.HasCoord() {
+		return 0, errors.New("missing station coordinates for " + trip.Route.String())
+	}
+
+	return uint64(math.Round(haversine(from.Coord(), to.Coord()) * ea.detour_factor)), nil
+}
+
+func (ea *estimate_route_api) GetAll(ctx context.Context, trips Trips) map[Trip]uint64 {
+	result := make(map[Trip]uint64)
+
+	for _, trip := range trips {
+		distance, err := ea.Get(ctx, trip)
+		if err != nil {
+			log.Println("EstimateRouteAPI GET error ->", err)
+			continue
+		}
+		result[trip] = distance
+	}
+
+	return result
+}
+
+func (ea *estimate_route_api) GetRoute(ctx context.Context, trip Trip) (RoutedTrip, error) {
+	distance, err := ea.Get(ctx, trip)
+	if err != nil {
+		return RoutedTrip{}, err
+	}
+
+	return RoutedTrip{trip, distance, StraightPath(trip.Route)}, nil
+}
+
+func (ea *estimate_route_api) GetAllRoutes(ctx context.Context, trips Trips) []RoutedTrip {
+	var result []RoutedTrip
+
+	for _, trip := range trips {
+		routed, err := ea.GetRoute(ctx, trip)
+		if err != nil {
+			log.Println("EstimateRouteAPI GET error ->", err)
+			routed = RoutedTrip{trip, 0, StraightPath(trip.Route)}
+		}
+		result = append(result, routed)
+	}
+
+	return result
+}
+
+// haversine returns the great-circle distance between a and b in meters.
+func haversine(a, b distance.Coord) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dlat := lat2 - lat1
+	dlng := radians(b.Lng - a.Lng)
+
+	h := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dlng/2)*math.Sin(dlng/2)
+
+	return 2 * earth_radius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
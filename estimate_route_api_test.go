@@ -0,0 +1,59 @@
+package bikage_test
+
+import (
+	"context"
+	. "github.com/Bowbaq/bikage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EstimateRouteAPI", func() {
+	// ~1.1km apart, along Broadway
+	from := Station{Id: 1, Label: "Broadway & W 60 St", Lat: 40.76915505, Lng: -73.98191841}
+	to := Station{Id: 2, Label: "Broadway & W 49 St", Lat: 40.76064679, Lng: -73.98442659}
+
+	trip := Trip{Id: "1", Route: Route{From: from, To: to}}
+
+	Describe("Get()", func() {
+		It("returns the straight line distance when the detour factor is 1", func() {
+			dist, err := NewEstimateRouteAPI(1).Get(context.Background(), trip)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dist).To(BeNumerically("~", 967, 5))
+		})
+
+		It("applies the detour factor", func() {
+			dist, err := NewEstimateRouteAPI(2).Get(context.Background(), trip)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dist).To(BeNumerically("~", 2*967, 10))
+		})
+
+		It("fails when a station has no coordinates", func() {
+			_, err := NewEstimateRouteAPI(1).Get(context.Background(), Trip{Route: Route{From: from, To: Station{Id: 3}}})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("GetAll()", func() {
+		It("skips trips that can't be estimated", func() {
+			broken := Trip{Id: "2", Route: Route{From: from, To: Station{Id: 3}}}
+
+			distances := NewEstimateRouteAPI(0).GetAll(context.Background(), Trips{trip, broken})
+			Expect(distances).To(HaveLen(1))
+			Expect(distances).To(HaveKey(trip))
+		})
+	})
+
+	Describe("GetAllRoutes()", func() {
+		It("keeps the trips that can't be estimated, without a distance", func() {
+			broken := Trip{Id: "2", Route: Route{From: from, To: Station{Id: 3}}}
+
+			routes := NewEstimateRouteAPI(0).GetAllRoutes(context.Background(), Trips{trip, broken})
+			Expect(routes).To(HaveLen(2))
+			Expect(routes[0].Distance).To(BeNumerically(">", 0))
+			Expect(routes[1].Trip).To(Equal(broken))
+			Expect(routes[1].Distance).To(BeZero())
+			Expect(routes[1].Path).To(Equal(StraightPath(broken.Route)))
+		})
+	})
+})
@@ -0,0 +1,75 @@
+// Package export writes trips to formats understood by mapping tools: GPX
+// tracks (Strava), KML placemarks (Google Earth) and GeoJSON (QGIS).
+package export
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Bowbaq/bikage"
+)
+
+// Supported formats
+const (
+	GPX     = "gpx"
+	KML     = "kml"
+	GeoJSON = "geojson"
+)
+
+var content_types = map[string]string{
+	GPX:     "application/gpx+xml",
+	KML:     "application/vnd.google-earth.kml+xml",
+	GeoJSON: "application/geo+json",
+}
+
+// Write exports the trips to w in the given format. Trips without a route
+// geometry are drawn as a straight line between their stations.
+func Write(w io.Writer, format string, trips []bikage.RoutedTrip) error {
+	switch format {
+	case GPX:
+		return WriteGPX(w, trips)
+	case KML:
+		return WriteKML(w, trips)
+	case GeoJSON:
+		return WriteGeoJSON(w, trips)
+	}
+
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// ContentType returns the MIME type of the format, or "" if it is unknown.
+func ContentType(format string) string {
+	return content_types[format]
+}
+
+func path_of(trip bikage.RoutedTrip) bikage.Path {
+	if len(trip.Path) >= 2 {
+		return trip.Path
+	}
+
+	return bikage.StraightPath(trip.Route)
+}
+
+// timestamps spreads the duration of the trip along the path, proportionally
+// to the distance covered.
+func timestamps(trip bikage.RoutedTrip, path bikage.Path) []time.Time {
+	cumulative := make([]float64, len(path))
+	for i := 1; i < len(path); i++ {
+		cumulative[i] = cumulative[i-1] + path[i-1].DistanceTo(path[i])
+	}
+
+	total := cumulative[len(path)-1]
+	duration := trip.Duration()
+
+	times := make([]time.Time, len(path))
+	for i := range path {
+		ratio := float64(i) / float64(len(path)-1)
+		if total > 0 {
+			ratio = cumulative[i] / total
+		}
+		times[i] = trip.StartedAt.Add(time.Duration(ratio * float64(duration))).Round(time.Second)
+	}
+
+	return times
+}
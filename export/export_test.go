@@ -0,0 +1,157 @@
+package export_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"time"
+
+	"github.com/Bowbaq/bikage"
+	. "github.com/Bowbaq/bikage/export"
+	"github.com/Bowbaq/distance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// no_directions can't route any trip
+type no_directions struct{}
+
+func (no_directions) GetDistance(trip distance.Trip) (uint64, error) {
+	return 0, errors.New("no route")
+}
+func (no_directions) GetDistances(trips []distance.Trip) map[distance.Trip]uint64 {
+	return make(map[distance.Trip]uint64)
+}
+
+var _ = Describe("export", func() {
+	started_at := time.Date(2014, 7, 1, 8, 0, 0, 0, time.UTC)
+
+	routed := bikage.RoutedTrip{
+		Trip: bikage.Trip{
+			Id:        "routed",
+			Route:     bikage.Route{From: bikage.Station{Label: "A", Lat: 40.0, Lng: -73.0}, To: bikage.Station{Label: "C", Lat: 40.002, Lng: -73.0}},
+			StartedAt: started_at,
+			EndedAt:   started_at.Add(10 * time.Minute),
+		},
+		Distance: 250,
+		Path:     bikage.Path{{Lat: 40.0, Lng: -73.0, Ele: 10}, {Lat: 40.001, Lng: -73.0, Ele: 12}, {Lat: 40.002, Lng: -73.0, Ele: 11}},
+	}
+	straight := bikage.RoutedTrip{
+		Trip: bikage.Trip{
+			Id:        "straight",
+			Route:     bikage.Route{From: bikage.Station{Label: "C", Lat: 40.002, Lng: -73.0}, To: bikage.Station{Label: "A", Lat: 40.0, Lng: -73.0}},
+			StartedAt: started_at.Add(time.Hour),
+			EndedAt:   started_at.Add(time.Hour + 5*time.Minute),
+		},
+		Distance: 260,
+	}
+	trips := []bikage.RoutedTrip{routed, straight}
+
+	Describe("WriteGeoJSON()", func() {
+		var collection struct {
+			Type     string
+			Features []struct {
+				Geometry struct {
+					Type        string
+					Coordinates [][]float64
+				}
+				Properties map[string]interface{}
+			}
+		}
+
+		BeforeEach(func() {
+			var buf bytes.Buffer
+			Expect(WriteGeoJSON(&buf, trips)).To(Succeed())
+			Expect(json.Unmarshal(buf.Bytes(), &collection)).To(Succeed())
+		})
+
+		It("writes a feature per trip", func() {
+			Expect(collection.Type).To(Equal("FeatureCollection"))
+			Expect(collection.Features).To(HaveLen(2))
+			Expect(collection.Features[0].Properties).To(HaveKeyWithValue("id", "routed"))
+		})
+
+		It("uses the route geometry when available", func() {
+			Expect(collection.Features[0].Geometry.Coordinates).To(Equal([][]float64{{-73.0, 40.0, 10}, {-73.0, 40.001, 12}, {-73.0, 40.002, 11}}))
+		})
+
+		It("falls back to a straight line between the stations", func() {
+			Expect(collection.Features[1].Geometry.Coordinates).To(Equal([][]float64{{-73.0, 40.002}, {-73.0, 40.0}}))
+		})
+	})
+
+	Describe("WriteGPX()", func() {
+		var doc struct {
+			Tracks []struct {
+				Points []struct {
+					Ele  *float64 `xml:"ele"`
+					Time string   `xml:"time"`
+				} `xml:"trkseg>trkpt"`
+			} `xml:"trk"`
+		}
+
+		BeforeEach(func() {
+			var buf bytes.Buffer
+			Expect(WriteGPX(&buf, trips)).To(Succeed())
+			Expect(xml.Unmarshal(buf.Bytes(), &doc)).To(Succeed())
+		})
+
+		It("interpolates timestamps along the track", func() {
+			Expect(doc.Tracks).To(HaveLen(2))
+			Expect(doc.Tracks[0].Points).To(HaveLen(3))
+			Expect(doc.Tracks[0].Points[0].Time).To(Equal("2014-07-01T08:00:00Z"))
+			Expect(doc.Tracks[0].Points[1].Time).To(Equal("2014-07-01T08:05:00Z"))
+			Expect(doc.Tracks[0].Points[2].Time).To(Equal("2014-07-01T08:10:00Z"))
+		})
+
+		It("writes the known elevations, even at sea level", func() {
+			shore := routed
+			shore.Path = bikage.Path{{Lat: 40.0, Lng: -73.0, HasEle: true}, {Lat: 40.002, Lng: -73.0, Ele: 3}}
+
+			var buf bytes.Buffer
+			Expect(WriteGPX(&buf, []bikage.RoutedTrip{shore, straight})).To(Succeed())
+
+			shore_doc := doc
+			shore_doc.Tracks = nil
+			Expect(xml.Unmarshal(buf.Bytes(), &shore_doc)).To(Succeed())
+
+			Expect(*shore_doc.Tracks[0].Points[0].Ele).To(BeNumerically("==", 0))
+			Expect(*shore_doc.Tracks[0].Points[1].Ele).To(BeNumerically("==", 3))
+			Expect(shore_doc.Tracks[1].Points[0].Ele).To(BeNil())
+		})
+	})
+
+	Describe("Write()", func() {
+		It("rejects unknown formats", func() {
+			Expect(Write(new(bytes.Buffer), "shp", trips)).NotTo(Succeed())
+		})
+
+		It("writes KML", func() {
+			var buf bytes.Buffer
+			Expect(Write(&buf, KML, trips)).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring("<Placemark>"))
+		})
+
+		It("exports the trips that can't be routed as a straight line", func() {
+			bk := &bikage.Bikage{RouteAPI: bikage.NewRouteAPI(no_directions{})}
+			routes := bk.RouteTrips(context.Background(), bikage.Trips{straight.Trip})
+
+			var buf bytes.Buffer
+			Expect(Write(&buf, GeoJSON, routes)).To(Succeed())
+
+			var collection struct {
+				Features []struct {
+					Geometry struct {
+						Coordinates [][]float64
+					}
+				}
+			}
+			Expect(json.Unmarshal(buf.Bytes(), &collection)).To(Succeed())
+			Expect(collection.Features).To(HaveLen(1))
+			Expect(collection.Features[0].Geometry.Coordinates).To(Equal([][]float64{{-73.0, 40.002}, {-73.0, 40.0}}))
+		})
+	})
+})
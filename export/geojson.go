@@ -0,0 +1,57 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/Bowbaq/bikage"
+)
+
+type feature_collection struct {
+	Type     string    `json:"type"`
+	Features []feature `json:"features"`
+}
+
+type feature struct {
+	Type       string                 `json:"type"`
+	Geometry   line_string            `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type line_string struct {
+	Type        string      `json:"type"`
+	Coordinates [][]float64 `json:"coordinates"`
+}
+
+// WriteGeoJSON writes a FeatureCollection with one LineString feature per trip.
+func WriteGeoJSON(w io.Writer, trips []bikage.RoutedTrip) error {
+	collection := feature_collection{Type: "FeatureCollection", Features: make([]feature, 0)}
+
+	for _, trip := range trips {
+		coordinates := make([][]float64, 0)
+		for _, point := range path_of(trip) {
+			if ele, ok := point.Elevation(); ok {
+				coordinates = append(coordinates, []float64{point.Lng, point.Lat, ele})
+			} else {
+				coordinates = append(coordinates, []float64{point.Lng, point.Lat})
+			}
+		}
+
+		collection.Features = append(collection.Features, feature{
+			Type:     "Feature",
+			Geometry: line_string{Type: "LineString", Coordinates: coordinates},
+			Properties: map[string]interface{}{
+				"id":         trip.Id,
+				"from":       trip.Route.From.Label,
+				"to":         trip.Route.To.Label,
+				"started_at": trip.StartedAt.UTC().Format(time.RFC3339),
+				"ended_at":   trip.EndedAt.UTC().Format(time.RFC3339),
+				"distance":   trip.Distance,
+				"duration":   trip.Duration().Seconds(),
+			},
+		})
+	}
+
+	return json.NewEncoder(w).Encode(collection)
+}
@@ -0,0 +1,68 @@
+package export
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+
+	"github.com/Bowbaq/bikage"
+)
+
+type gpx struct {
+	XMLName xml.Name    `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+	Version string      `xml:"version,attr"`
+	Creator string      `xml:"creator,attr"`
+	Tracks  []gpx_track `xml:"trk"`
+}
+
+type gpx_track struct {
+	Name    string      `xml:"name"`
+	Type    string      `xml:"type"`
+	Segment []gpx_trkpt `xml:"trkseg>trkpt"`
+}
+
+type gpx_trkpt struct {
+	Lat  float64  `xml:"lat,attr"`
+	Lon  float64  `xml:"lon,attr"`
+	Ele  *float64 `xml:"ele,omitempty"`
+	Time string   `xml:"time"`
+}
+
+// WriteGPX writes one GPX track per trip. Point timestamps are interpolated
+// between the start and end of the trip.
+func WriteGPX(w io.Writer, trips []bikage.RoutedTrip) error {
+	doc := gpx{Version: "1.1", Creator: "bikage"}
+
+	for _, trip := range trips {
+		path := path_of(trip)
+		times := timestamps(trip, path)
+
+		track := gpx_track{Name: trip.Route.String(), Type: "cycling"}
+		for i, point := range path {
+			trkpt := gpx_trkpt{Lat: point.Lat, Lon: point.Lng, Time: times[i].UTC().Format(time.RFC3339)}
+			if ele, ok := point.Elevation(); ok {
+				trkpt.Ele = &ele
+			}
+			track.Segment = append(track.Segment, trkpt)
+		}
+
+		doc.Tracks = append(doc.Tracks, track)
+	}
+
+	return write_xml(w, doc)
+}
+
+func write_xml(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,49 @@
+package export
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Bowbaq/bikage"
+)
+
+type kml struct {
+	XMLName    xml.Name        `xml:"http://www.opengis.net/kml/2.2 kml"`
+	Name       string          `xml:"Document>name"`
+	Placemarks []kml_placemark `xml:"Document>Placemark"`
+}
+
+type kml_placemark struct {
+	Name        string `xml:"name"`
+	Description string `xml:"description"`
+	Begin       string `xml:"TimeSpan>begin"`
+	End         string `xml:"TimeSpan>end"`
+	Tessellate  int    `xml:"LineString>tessellate"`
+	Coordinates string `xml:"LineString>coordinates"`
+}
+
+// WriteKML writes one placemark per trip, with the trip drawn as a line string.
+func WriteKML(w io.Writer, trips []bikage.RoutedTrip) error {
+	doc := kml{Name: "Bikage trips"}
+
+	for _, trip := range trips {
+		coordinates := make([]string, 0)
+		for _, point := range path_of(trip) {
+			coordinates = append(coordinates, fmt.Sprintf("%f,%f,%f", point.Lng, point.Lat, point.Ele))
+		}
+
+		doc.Placemarks = append(doc.Placemarks, kml_placemark{
+			Name:        trip.Route.String(),
+			Description: fmt.Sprintf("%.2f km in %s", float64(trip.Distance)/1000, trip.Duration()),
+			Begin:       trip.StartedAt.UTC().Format(time.RFC3339),
+			End:         trip.EndedAt.UTC().Format(time.RFC3339),
+			Tessellate:  1,
+			Coordinates: strings.Join(coordinates, " "),
+		})
+	}
+
+	return write_xml(w, doc)
+}
@@ -0,0 +1,220 @@
+package bikage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// GBFSClient reads station metadata from a General Bikeshare Feed
+// Specification (https://gbfs.org) publisher. The endpoint is the gbfs.json
+// auto-discovery file, either an http(s) url or a local file, in which case
+// relative feed urls are resolved against its directory.
+type GBFSClient struct {
+	endpoint string
+	http     *http.Client
+	retry    RetryPolicy
+}
+
+func NewGBFSClient(endpoint string) *GBFSClient {
+	return &GBFSClient{
+		endpoint: endpoint,
+		http:     &http.Client{Timeout: http_timeout},
+		retry:    DefaultRetryPolicy,
+	}
+}
+
+type gbfs_feed struct {
+	Name string `json:"name"`
+	Url  string `json:"url"`
+}
+
+type gbfs_station_information struct {
+	StationId string  `json:"station_id"`
+	LegacyId  string  `json:"legacy_id"`
+	Name      string  `json:"name"`
+	Lat       float64 `json:"lat"`
+	Lon       float64 `json:"lon"`
+}
+
+type gbfs_station_status struct {
+	StationId   string    `json:"station_id"`
+	IsInstalled gbfs_bool `json:"is_installed"`
+	IsRenting   gbfs_bool `json:"is_renting"`
+}
+
+// GetStations returns the installed stations, keyed by label. A station is
+// StationActive when it is renting bikes, StationInactive otherwise.
+func (c *GBFSClient) GetStations(ctx context.Context) (Stations, error) {
+	base, err := gbfs_url(c.endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	feeds, err := c.discover(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+
+	info_url, ok := feeds["station_information"]
+	if !ok {
+		return nil, errors.New("GBFS feed station_information not found")
+	}
+
+	var information struct {
+		Stations []gbfs_station_information `json:"stations"`
+	}
+	if err := c.get_feed(ctx, base, info_url, &information); err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]gbfs_station_status)
+	if status_url, ok := feeds["station_status"]; ok {
+		var status struct {
+			Stations []gbfs_station_status `json:"stations"`
+		}
+		if err := c.get_feed(ctx, base, status_url, &status); err != nil {
+			return nil, err
+		}
+
+		for _, s := range status.Stations {
+			statuses[s.StationId] = s
+		}
+	}
+
+	stations := make(Stations)
+	for _, info := range information.Stations {
+		station := Station{
+			Id:     parse_station_id(info.StationId),
+			Label:  info.Name,
+			Status: StationActive,
+			Lat:    info.Lat,
+			Lng:    info.Lon,
+		}
+		if info.LegacyId != "" {
+			station.Id = parse_station_id(info.LegacyId)
+		}
+
+		if status, ok := statuses[info.StationId]; ok {
+			if !status.IsInstalled {
+				continue
+			}
+			if !status.IsRenting {
+				station.Status = StationInactive
+			}
+		}
+
+		stations[station.Label] = station
+	}
+
+	return stations, nil
+}
+
+// discover returns the feed urls listed in gbfs.json, keyed by name. English
+// feeds are preferred when several languages are published.
+func (c *GBFSClient) discover(ctx context.Context, base *url.URL) (map[string]string, error) {
+	var discovery map[string]json.RawMessage
+	if err := c.get_feed(ctx, base, base.String(), &discovery); err != nil {
+		return nil, err
+	}
+
+	var feeds struct {
+		Feeds []gbfs_feed `json:"feeds"`
+	}
+
+	// GBFS v3 lists the feeds directly, earlier versions list them by language
+	if raw, ok := discovery["feeds"]; ok {
+		if err := json.Unmarshal(raw, &feeds.Feeds); err != nil {
+			return nil, err
+		}
+	} else if raw, ok := discovery["en"]; ok {
+		if err := json.Unmarshal(raw, &feeds); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, raw := range discovery {
+			if err := json.Unmarshal(raw, &feeds); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	urls := make(map[string]string)
+	for _, feed := range feeds.Feeds {
+		urls[feed.Name] = feed.Url
+	}
+
+	return urls, nil
+}
+
+// get_feed decodes the data field of the feed at feed_url into v
+func (c *GBFSClient) get_feed(ctx context.Context, base *url.URL, feed_url string, v interface{}) error {
+	ref, err := url.Parse(feed_url)
+	if err != nil {
+		return err
+	}
+	location := base.ResolveReference(ref)
+
+	var body io.ReadCloser
+	if location.Scheme == "file" {
+		body, err = os.Open(location.Path)
+		if err != nil {
+			return err
+		}
+	} else {
+		resp, err := c.retry.get(ctx, c.http, location.String())
+		if err != nil {
+			return fmt.Errorf("GBFS %v", err)
+		}
+		body = resp.Body
+	}
+	defer body.Close()
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(body).Decode(&envelope); err != nil {
+		return fmt.Errorf("GBFS DECODE %s -> %v", location, err)
+	}
+
+	return json.Unmarshal(envelope.Data, v)
+}
+
+// gbfs_url parses the endpoint, local paths are turned into file urls
+func gbfs_url(endpoint string) (*url.URL, error) {
+	u, err := url.Parse(endpoint)
+	if err == nil && u.Scheme != "" && len(u.Scheme) > 1 {
+		return u, nil
+	}
+
+	path, err := filepath.Abs(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &url.URL{Scheme: "file", Path: filepath.ToSlash(path)}, nil
+}
+
+// gbfs_bool accepts both the 0/1 integers of GBFS v1 and the booleans of later
+// versions
+type gbfs_bool bool
+
+func (b *gbfs_bool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "1", "true":
+		*b = true
+	case "0", "false", "null":
+		*b = false
+	default:
+		return fmt.Errorf("invalid GBFS boolean %s", data)
+	}
+
+	return nil
+}
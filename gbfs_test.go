@@ -0,0 +1,120 @@
+package bikage_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/Bowbaq/bikage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GBFSClient", func() {
+	Describe("GetStations()", func() {
+		Context("from a GBFS v2 server", func() {
+			var (
+				server   *httptest.Server
+				failures int
+			)
+
+			BeforeEach(func() {
+				failures = 0
+
+				mux := http.NewServeMux()
+				mux.HandleFunc("/gbfs.json", func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"data": {"en": {"feeds": [
+						{"name": "station_information", "url": "http://` + r.Host + `/en/station_information.json"},
+						{"name": "station_status", "url": "http://` + r.Host + `/en/station_status.json"}
+					]}}}`))
+				})
+				mux.HandleFunc("/en/station_information.json", func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"data": {"stations": [
+						{"station_id": "72", "name": "W 52 St & 11 Ave", "lat": 40.76727216, "lon": -73.99392888},
+						{"station_id": "66db237e", "legacy_id": "79", "name": "Franklin St & W Broadway", "lat": 40.71911552, "lon": -74.00666661},
+						{"station_id": "3000", "name": "Removed", "lat": 40.7, "lon": -73.9}
+					]}}`))
+				})
+				mux.HandleFunc("/en/station_status.json", func(w http.ResponseWriter, r *http.Request) {
+					if failures > 0 {
+						failures--
+						w.WriteHeader(http.StatusServiceUnavailable)
+						return
+					}
+					w.Write([]byte(`{"data": {"stations": [
+						{"station_id": "72", "is_installed": 1, "is_renting": 1},
+						{"station_id": "66db237e", "is_installed": 1, "is_renting": 0},
+						{"station_id": "3000", "is_installed": 0, "is_renting": 0}
+					]}}`))
+				})
+				server = httptest.NewServer(mux)
+			})
+
+			AfterEach(func() {
+				server.Close()
+			})
+
+			It("returns the installed stations keyed by label", func() {
+				stations, err := NewGBFSClient(server.URL + "/gbfs.json").GetStations(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stations).To(HaveLen(2))
+				Expect(stations).To(HaveKeyWithValue("W 52 St & 11 Ave", Station{Id: 72, Label: "W 52 St & 11 Ave", Status: StationActive, Lat: 40.76727216, Lng: -73.99392888}))
+			})
+
+			It("prefers legacy ids and reports stations that aren't renting", func() {
+				stations, _ := NewGBFSClient(server.URL + "/gbfs.json").GetStations(context.Background())
+				Expect(stations["Franklin St & W Broadway"].Id).To(BeNumerically("==", 79))
+				Expect(stations["Franklin St & W Broadway"].Status).To(Equal(StationInactive))
+			})
+
+			It("retries transient failures", func() {
+				failures = 1
+				stations, err := NewGBFSClient(server.URL + "/gbfs.json").GetStations(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stations).To(HaveLen(2))
+				Expect(failures).To(BeZero())
+			})
+
+			It("gives up when the context is cancelled", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				_, err := NewGBFSClient(server.URL + "/gbfs.json").GetStations(ctx)
+				Expect(err).To(MatchError(ContainSubstring("context canceled")))
+			})
+
+			It("fails when the feed is missing", func() {
+				_, err := NewGBFSClient(server.URL + "/missing.json").GetStations(context.Background())
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("from local GBFS v3 files", func() {
+			var dir string
+
+			BeforeEach(func() {
+				dir, _ = ioutil.TempDir("", "gbfs")
+				ioutil.WriteFile(filepath.Join(dir, "gbfs.json"), []byte(`{"data": {"feeds": [
+					{"name": "station_information", "url": "station_information.json"}
+				]}}`), 0644)
+				ioutil.WriteFile(filepath.Join(dir, "station_information.json"), []byte(`{"data": {"stations": [
+					{"station_id": "72", "name": "W 52 St & 11 Ave", "lat": 40.76727216, "lon": -73.99392888}
+				]}}`), 0644)
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(dir)
+			})
+
+			It("resolves feeds relative to gbfs.json, stations without status are active", func() {
+				stations, err := NewGBFSClient(filepath.Join(dir, "gbfs.json")).GetStations(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stations).To(HaveKeyWithValue("W 52 St & 11 Ave", Station{Id: 72, Label: "W 52 St & 11 Ave", Status: StationActive, Lat: 40.76727216, Lng: -73.99392888}))
+			})
+		})
+	})
+})
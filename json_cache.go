@@ -12,15 +12,30 @@ import (
 const cache_path = "./bikage_cache.json"
 
 type JsonCache struct {
-	distances map[string]uint64
-	trips     map[string]map[string]Trip
+	path string
+
+	distances  map[string]uint64
+	paths      map[string]Path
+	trips      map[string]map[string]Trip
+	incomplete map[string]bool
+	stations   map[uint64][]StationVersion
+	aliases    map[string]StationAlias
 	sync.RWMutex
 }
 
 func NewJsonCache() *JsonCache {
+	return NewJsonCacheAt(cache_path)
+}
+
+func NewJsonCacheAt(path string) *JsonCache {
 	c := &JsonCache{
-		distances: make(map[string]uint64),
-		trips:     make(map[string]map[string]Trip),
+		path:       path,
+		distances:  make(map[string]uint64),
+		paths:      make(map[string]Path),
+		trips:      make(map[string]map[string]Trip),
+		incomplete: make(map[string]bool),
+		stations:   make(map[uint64][]StationVersion),
+		aliases:    make(map[string]StationAlias),
 	}
 
 	c.Lock()
@@ -47,6 +62,23 @@ func (c *JsonCache) PutDistance(route Route, distance uint64) {
 	c.Unlock()
 }
 
+func (c *JsonCache) GetPath(route Route) (Path, bool) {
+	c.RLock()
+	path, found := c.paths[make_key(route.From, route.To)]
+	c.RUnlock()
+
+	return path, found
+}
+
+func (c *JsonCache) PutPath(route Route, path Path) {
+	c.Lock()
+
+	c.paths[make_key(route.From, route.To)] = path
+	c.serialize()
+
+	c.Unlock()
+}
+
 func (c *JsonCache) GetTrip(username, id string) (Trip, bool) {
 	c.RLock()
 	user_trips, found := c.trips[username]
@@ -81,6 +113,10 @@ func (c *JsonCache) GetTrips(username string) Trips {
 	return trips
 }
 
+func (c *JsonCache) QueryTrips(username string, query TripQuery) Trips {
+	return query.Apply(c.GetTrips(username))
+}
+
 func (c *JsonCache) PutTrip(username string, trip Trip) {
 	c.Lock()
 
@@ -95,13 +131,94 @@ func (c *JsonCache) PutTrip(username string, trip Trip) {
 	c.Unlock()
 }
 
+func (c *JsonCache) GetIncomplete(username string) bool {
+	c.RLock()
+	incomplete := c.incomplete[username]
+	c.RUnlock()
+
+	return incomplete
+}
+
+func (c *JsonCache) PutIncomplete(username string, incomplete bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.incomplete[username] == incomplete {
+		return
+	}
+
+	if incomplete {
+		c.incomplete[username] = true
+	} else {
+		delete(c.incomplete, username)
+	}
+	c.serialize()
+}
+
+func (c *JsonCache) GetStationHistory() map[uint64][]StationVersion {
+	history := make(map[uint64][]StationVersion)
+
+	c.RLock()
+	for id, versions := range c.stations {
+		history[id] = append([]StationVersion(nil), versions...)
+	}
+	c.RUnlock()
+
+	return history
+}
+
+func (c *JsonCache) PutStationHistory(history map[uint64][]StationVersion) {
+	c.Lock()
+
+	for id, versions := range history {
+		c.stations[id] = versions
+	}
+	c.serialize()
+
+	c.Unlock()
+}
+
+func (c *JsonCache) GetAliases() []StationAlias {
+	aliases := make([]StationAlias, 0)
+
+	c.RLock()
+	for _, alias := range c.aliases {
+		aliases = append(aliases, alias)
+	}
+	c.RUnlock()
+
+	return aliases
+}
+
+func (c *JsonCache) PutAlias(alias StationAlias) {
+	c.Lock()
+
+	c.aliases[alias.Label] = alias
+	c.serialize()
+
+	c.Unlock()
+}
+
+func (c *JsonCache) DeleteAlias(label string) {
+	c.Lock()
+
+	delete(c.aliases, label)
+	c.serialize()
+
+	c.Unlock()
+}
+
 type serialized struct {
-	Distances map[string]uint64
-	Trips     map[string]map[string]Trip
+	Distances  map[string]uint64
+	Paths      map[string]Path
+	Trips      map[string]map[string]Trip
+	Incomplete map[string]bool
+	Stations   map[uint64][]StationVersion
+	Aliases    map[string]StationAlias
 }
 
 func (c *JsonCache) deserialize() {
-	data, err := ioutil.ReadFile(cache_path)
+	data, err := ioutil.ReadFile(c.path)
 	if err != nil {
 		log.Println("JsonCache READ error ->", err)
 		return
@@ -110,19 +227,35 @@ func (c *JsonCache) deserialize() {
 	var cache serialized
 	json.Unmarshal(data, &cache)
 
-	c.distances = cache.Distances
-	c.trips = cache.Trips
+	if cache.Distances != nil {
+		c.distances = cache.Distances
+	}
+	if cache.Paths != nil {
+		c.paths = cache.Paths
+	}
+	if cache.Trips != nil {
+		c.trips = cache.Trips
+	}
+	if cache.Incomplete != nil {
+		c.incomplete = cache.Incomplete
+	}
+	if cache.Stations != nil {
+		c.stations = cache.Stations
+	}
+	if cache.Aliases != nil {
+		c.aliases = cache.Aliases
+	}
 }
 
 func (c *JsonCache) serialize() {
-	cache := serialized{c.distances, c.trips}
+	cache := serialized{c.distances, c.paths, c.trips, c.incomplete, c.stations, c.aliases}
 	data, err := json.MarshalIndent(cache, "", "  ")
 	if err != nil {
 		log.Println("JsonCache MARSHALL error ->", err)
 		return
 	}
 
-	err = ioutil.WriteFile(cache_path, data, 0755)
+	err = ioutil.WriteFile(c.path, data, 0755)
 	if err != nil {
 		log.Println("JsonCache SERIALIZE error ->", err)
 	}
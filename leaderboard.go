@@ -0,0 +1,164 @@
+package bikage
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// StationUsage sums the trips from or to a station, round trips are counted
+// once in Trips
+type StationUsage struct {
+	Station     Station
+	Starts      int
+	Ends        int
+	Trips       int
+	Distance    uint64 // meters
+	AvgDuration time.Duration
+
+	duration time.Duration
+}
+
+// RouteUsage sums the trips along a route. RoundTrip is set when the trips
+// start and end at the same station, Returns counts the trips the other way.
+type RouteUsage struct {
+	Route       Route
+	Trips       int
+	Returns     int
+	RoundTrip   bool
+	Distance    uint64 // meters
+	AvgDuration time.Duration
+
+	duration time.Duration
+}
+
+type route_key struct {
+	from uint64
+	to   uint64
+}
+
+// TopStations returns the n most used stations, by number of trips then
+// distance. n <= 0 returns every station.
+func (bk *Bikage) TopStations(ctx context.Context, trips Trips, n int) []StationUsage {
+	distances := bk.RouteAPI.GetAll(ctx, trips)
+
+	sorted := make(Trips, len(trips))
+	copy(sorted, trips)
+	sort.Sort(sorted)
+
+	usages := make(map[uint64]*StationUsage)
+	usage := func(station Station) *StationUsage {
+		u, ok := usages[station.Id]
+		if !ok {
+			u = &StationUsage{}
+			usages[station.Id] = u
+		}
+		// Trips are sorted, keep the latest name of the station
+		u.Station = station
+
+		return u
+	}
+
+	for _, trip := range sorted {
+		from, to := usage(trip.Route.From), usage(trip.Route.To)
+		from.Starts++
+		to.Ends++
+
+		touched := []*StationUsage{from}
+		if to != from {
+			touched = append(touched, to)
+		}
+		for _, u := range touched {
+			u.Trips++
+			u.Distance += distances[trip]
+			u.duration += trip.Duration()
+		}
+	}
+
+	top := make([]StationUsage, 0, len(usages))
+	for _, u := range usages {
+		u.AvgDuration = u.duration / time.Duration(u.Trips)
+		top = append(top, *u)
+	}
+
+	sort.Sort(station_usages(top))
+
+	if n > 0 && n < len(top) {
+		top = top[:n]
+	}
+
+	return top
+}
+
+// TopRoutes returns the n most frequent routes, by number of trips then
+// distance. n <= 0 returns every route.
+func (bk *Bikage) TopRoutes(ctx context.Context, trips Trips, n int) []RouteUsage {
+	distances := bk.RouteAPI.GetAll(ctx, trips)
+
+	sorted := make(Trips, len(trips))
+	copy(sorted, trips)
+	sort.Sort(sorted)
+
+	usages := make(map[route_key]*RouteUsage)
+	for _, trip := range sorted {
+		key := route_key{trip.Route.From.Id, trip.Route.To.Id}
+
+		u, ok := usages[key]
+		if !ok {
+			u = &RouteUsage{RoundTrip: key.from == key.to}
+			usages[key] = u
+		}
+		u.Route = trip.Route
+		u.Trips++
+		u.Distance += distances[trip]
+		u.duration += trip.Duration()
+	}
+
+	top := make([]RouteUsage, 0, len(usages))
+	for key, u := range usages {
+		u.AvgDuration = u.duration / time.Duration(u.Trips)
+		if back, ok := usages[route_key{key.to, key.from}]; ok && !u.RoundTrip {
+			u.Returns = back.Trips
+		}
+		top = append(top, *u)
+	}
+
+	sort.Sort(route_usages(top))
+
+	if n > 0 && n < len(top) {
+		top = top[:n]
+	}
+
+	return top
+}
+
+type station_usages []StationUsage
+
+func (s station_usages) Len() int      { return len(s) }
+func (s station_usages) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s station_usages) Less(i, j int) bool {
+	if s[i].Trips != s[j].Trips {
+		return s[i].Trips > s[j].Trips
+	}
+	if s[i].Distance != s[j].Distance {
+		return s[i].Distance > s[j].Distance
+	}
+	return s[i].Station.Id < s[j].Station.Id
+}
+
+type route_usages []RouteUsage
+
+func (r route_usages) Len() int      { return len(r) }
+func (r route_usages) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r route_usages) Less(i, j int) bool {
+	if r[i].Trips != r[j].Trips {
+		return r[i].Trips > r[j].Trips
+	}
+	if r[i].Distance != r[j].Distance {
+		return r[i].Distance > r[j].Distance
+	}
+	if r[i].Route.From.Id != r[j].Route.From.Id {
+		return r[i].Route.From.Id < r[j].Route.From.Id
+	}
+	return r[i].Route.To.Id < r[j].Route.To.Id
+}
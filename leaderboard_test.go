@@ -0,0 +1,114 @@
+package bikage_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/Bowbaq/bikage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Leaderboards", func() {
+	route_api := test_route_api{}
+	bk := &Bikage{RouteAPI: &route_api, TripAPI: &test_trip_api{}, Location: time.UTC}
+
+	a := Station{Id: 1, Label: "A"}
+	b := Station{Id: 2, Label: "B"}
+	c := Station{Id: 3, Label: "C"}
+
+	start := time.Date(2014, 7, 2, 8, 0, 0, 0, time.UTC)
+	n := 0
+	trip := func(from, to Station, minutes int) Trip {
+		n++
+		started := start.Add(time.Duration(n) * time.Hour)
+		return Trip{
+			Id:        started.String(),
+			Route:     Route{From: from, To: to},
+			StartedAt: started,
+			EndedAt:   started.Add(time.Duration(minutes) * time.Minute),
+		}
+	}
+
+	// A commute between A and B, a ride around A and a trip from C
+	trips := Trips{
+		trip(a, b, 10), trip(b, a, 12), trip(a, b, 12), trip(b, a, 12), trip(a, b, 14),
+		trip(a, a, 30), trip(c, b, 15),
+	}
+
+	BeforeEach(func() {
+		route_api.get_all = func(trips Trips) map[Trip]uint64 {
+			distances := make(map[Trip]uint64)
+			for _, trip := range trips {
+				switch trip.Route {
+				case Route{From: c, To: b}:
+					distances[trip] = 3000
+				case Route{From: a, To: a}:
+					distances[trip] = 0
+				default:
+					distances[trip] = 2000
+				}
+			}
+			return distances
+		}
+	})
+
+	AfterEach(func() {
+		route_api.get_all = nil
+	})
+
+	Describe("TopStations()", func() {
+		It("ranks the stations by trips then distance", func() {
+			top := bk.TopStations(context.Background(), trips, 0)
+			Expect(top).To(HaveLen(3))
+
+			Expect(top[0].Station).To(Equal(b))
+			Expect(top[0].Trips).To(Equal(6))
+			Expect(top[0].Starts).To(Equal(2))
+			Expect(top[0].Ends).To(Equal(4))
+			Expect(top[0].Distance).To(BeNumerically("==", 13000))
+
+			// the round trip is counted once
+			Expect(top[1].Station).To(Equal(a))
+			Expect(top[1].Trips).To(Equal(6))
+			Expect(top[1].Starts).To(Equal(4))
+			Expect(top[1].Ends).To(Equal(3))
+			Expect(top[1].AvgDuration).To(Equal(15 * time.Minute))
+
+			Expect(top[2].Station).To(Equal(c))
+		})
+
+		It("keeps the first n stations", func() {
+			Expect(bk.TopStations(context.Background(), trips, 1)).To(HaveLen(1))
+		})
+	})
+
+	Describe("TopRoutes()", func() {
+		It("ranks the routes by trips then distance, with the trips back", func() {
+			top := bk.TopRoutes(context.Background(), trips, 0)
+			Expect(top).To(HaveLen(4))
+
+			Expect(top[0].Route).To(Equal(Route{From: a, To: b}))
+			Expect(top[0].Trips).To(Equal(3))
+			Expect(top[0].Returns).To(Equal(2))
+			Expect(top[0].Distance).To(BeNumerically("==", 6000))
+			Expect(top[0].AvgDuration).To(Equal(12 * time.Minute))
+
+			Expect(top[1].Route).To(Equal(Route{From: b, To: a}))
+			Expect(top[1].Returns).To(Equal(3))
+
+			Expect(top[2].Route).To(Equal(Route{From: c, To: b}))
+			Expect(top[2].Returns).To(BeZero())
+		})
+
+		It("flags the round trips", func() {
+			top := bk.TopRoutes(context.Background(), trips, 0)
+
+			Expect(top[3].Route).To(Equal(Route{From: a, To: a}))
+			Expect(top[3].RoundTrip).To(BeTrue())
+			Expect(top[3].Returns).To(BeZero())
+			Expect(top[0].RoundTrip).To(BeFalse())
+		})
+	})
+})
@@ -3,6 +3,7 @@ package bikage
 import (
 	"errors"
 	"log"
+	"time"
 
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
@@ -10,9 +11,16 @@ import (
 
 type MongoCache struct {
 	session *mgo.Session
+	prefix  string
 }
 
 func NewMongoCache(mongo_url string) (*MongoCache, error) {
+	return NewPrefixedMongoCache(mongo_url, "")
+}
+
+// NewPrefixedMongoCache stores the data in collections named after prefix, so
+// that several caches can share a database.
+func NewPrefixedMongoCache(mongo_url, prefix string) (*MongoCache, error) {
 	if mongo_url == "" {
 		return nil, errors.New("mongo url is empty")
 	}
@@ -23,6 +31,8 @@ func NewMongoCache(mongo_url string) (*MongoCache, error) {
 	}
 	session.SetSafe(&mgo.Safe{})
 
+	c := &MongoCache{session, prefix}
+
 	username_index := mgo.Index{
 		Key:        []string{"username"},
 		Unique:     false,
@@ -30,7 +40,7 @@ func NewMongoCache(mongo_url string) (*MongoCache, error) {
 		Background: true,
 		Sparse:     true,
 	}
-	session.DB("").C("trips").EnsureIndex(username_index)
+	c.collection(session, "trips").EnsureIndex(username_index)
 
 	trip_id_index := mgo.Index{
 		Key:        []string{"username", "trip.id"},
@@ -39,9 +49,25 @@ func NewMongoCache(mongo_url string) (*MongoCache, error) {
 		Background: true,
 		Sparse:     true,
 	}
-	session.DB("").C("trips").EnsureIndex(trip_id_index)
+	c.collection(session, "trips").EnsureIndex(trip_id_index)
+
+	// Indexes used by QueryTrips
+	for _, key := range []string{"trip.startedat", "trip.route.from.id", "trip.route.to.id"} {
+		c.collection(session, "trips").EnsureIndex(mgo.Index{
+			Key:        []string{"username", key},
+			Background: true,
+		})
+	}
+
+	return c, nil
+}
+
+func (c *MongoCache) collection(s *mgo.Session, name string) *mgo.Collection {
+	if c.prefix != "" {
+		name = c.prefix + "_" + name
+	}
 
-	return &MongoCache{session}, nil
+	return s.DB("").C(name)
 }
 
 type CachedRoute struct {
@@ -49,6 +75,7 @@ type CachedRoute struct {
 	From     uint64        `bson:"from"`
 	To       uint64        `bson:"to"`
 	Distance uint64        `bson:"distance"`
+	Path     Path          `bson:"path,omitempty"`
 }
 
 func NewCachedRoute(route Route, distance uint64) CachedRoute {
@@ -67,7 +94,7 @@ func (c *MongoCache) GetDistance(route Route) (uint64, bool) {
 	defer s.Close()
 
 	query := bson.M{"from": route.From.Id, "to": route.To.Id}
-	err := s.DB("").C("routes").Find(query).One(&cached)
+	err := c.collection(s, "routes").Find(query).One(&cached)
 
 	if err != nil {
 		log.Println("MongoCache: GET error -> ", query, err)
@@ -81,12 +108,42 @@ func (c *MongoCache) PutDistance(route Route, distance uint64) {
 	s := c.session.Clone()
 	defer s.Close()
 
-	err := s.DB("").C("routes").Insert(NewCachedRoute(route, distance))
+	err := c.collection(s, "routes").Insert(NewCachedRoute(route, distance))
 	if err != nil && !mgo.IsDup(err) {
 		log.Println("MongoCache: PUT error -> ", err)
 	}
 }
 
+func (c *MongoCache) GetPath(route Route) (Path, bool) {
+	var cached CachedRoute
+
+	s := c.session.Clone()
+	defer s.Close()
+
+	query := bson.M{"from": route.From.Id, "to": route.To.Id, "path": bson.M{"$exists": true}}
+	err := c.collection(s, "routes").Find(query).One(&cached)
+
+	if err != nil {
+		log.Println("MongoCache: GET error -> ", query, err)
+		return nil, false
+	}
+
+	return cached.Path, true
+}
+
+// PutPath attaches the path to the cached route, the distance must have been
+// cached first.
+func (c *MongoCache) PutPath(route Route, path Path) {
+	s := c.session.Clone()
+	defer s.Close()
+
+	query := bson.M{"from": route.From.Id, "to": route.To.Id}
+	err := c.collection(s, "routes").Update(query, bson.M{"$set": bson.M{"path": path}})
+	if err != nil {
+		log.Println("MongoCache: PUT error -> ", query, err)
+	}
+}
+
 type CachedTrip struct {
 	MgoId    bson.ObjectId `bson:"_id"`
 	Username string
@@ -108,7 +165,7 @@ func (c *MongoCache) GetTrip(username, id string) (Trip, bool) {
 	defer s.Close()
 
 	query := bson.M{"username": username, "trip.id": id}
-	err := s.DB("").C("trips").Find(query).One(&cached)
+	err := c.collection(s, "trips").Find(query).One(&cached)
 
 	if err != nil {
 		log.Println("MongoCache: GET error -> ", query, err)
@@ -125,7 +182,7 @@ func (c *MongoCache) GetTrips(username string) Trips {
 	defer s.Close()
 
 	query := bson.M{"username": username}
-	err := s.DB("").C("trips").Find(query).All(&cached)
+	err := c.collection(s, "trips").Find(query).All(&cached)
 
 	trips := make(Trips, 0)
 
@@ -141,12 +198,253 @@ func (c *MongoCache) GetTrips(username string) Trips {
 	return trips
 }
 
+// QueryTrips runs the query in the database, the weekday and time of day
+// filters require MongoDB 3.6 or later
+func (c *MongoCache) QueryTrips(username string, query TripQuery) Trips {
+	var cached []CachedTrip
+
+	s := c.session.Clone()
+	defer s.Close()
+
+	// MongoDB only knows the zones by their IANA name, the weekday and time of
+	// day filters are applied in memory for the others, like time.Local
+	native := query
+	_, named := mongo_zone(query.location())
+	in_memory := !named && (len(query.Weekdays) > 0 || query.After > 0 || query.Before > 0)
+	if in_memory {
+		native.Weekdays, native.After, native.Before = nil, 0, 0
+		native.Offset, native.Limit = 0, 0
+	}
+
+	selector := mongo_trip_query(username, native)
+	find := c.collection(s, "trips").Find(selector).Sort("trip.startedat")
+	if native.Offset > 0 {
+		find = find.Skip(native.Offset)
+	}
+	if native.Limit > 0 {
+		find = find.Limit(native.Limit)
+	}
+	err := find.All(&cached)
+
+	trips := make(Trips, 0)
+
+	if err != nil {
+		log.Println("MongoCache: QUERY error -> ", selector, err)
+		return trips
+	}
+
+	for _, trip := range cached {
+		trips = append(trips, trip.Trip)
+	}
+
+	if in_memory {
+		return query.Apply(trips)
+	}
+
+	return trips
+}
+
+// mongo_zone returns the IANA name of the zone, false if it has none
+func mongo_zone(location *time.Location) (string, bool) {
+	name := location.String()
+	if name == "" || name == "Local" {
+		return "", false
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return "", false
+	}
+
+	return name, true
+}
+
+func mongo_trip_query(username string, q TripQuery) bson.M {
+	query := bson.M{"username": username}
+
+	started := bson.M{}
+	if !q.From.IsZero() {
+		started["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		started["$lt"] = q.To
+	}
+	if len(started) > 0 {
+		query["trip.startedat"] = started
+	}
+
+	if q.StartStation != 0 {
+		query["trip.route.from.id"] = q.StartStation
+	}
+	if q.EndStation != 0 {
+		query["trip.route.to.id"] = q.EndStation
+	}
+
+	exprs := make([]bson.M, 0)
+
+	// in milliseconds
+	duration := bson.M{"$subtract": []interface{}{"$trip.endedat", "$trip.startedat"}}
+	if q.MinDuration > 0 {
+		exprs = append(exprs, bson.M{"$gte": []interface{}{duration, int64(q.MinDuration / time.Millisecond)}})
+	}
+	if q.MaxDuration > 0 {
+		exprs = append(exprs, bson.M{"$lte": []interface{}{duration, int64(q.MaxDuration / time.Millisecond)}})
+	}
+
+	zone, _ := mongo_zone(q.location())
+	started_at := bson.M{"date": "$trip.startedat", "timezone": zone}
+
+	if len(q.Weekdays) > 0 {
+		// $dayOfWeek is 1 on Sunday
+		days := make([]int, 0, len(q.Weekdays))
+		for _, day := range q.Weekdays {
+			days = append(days, int(day)+1)
+		}
+		exprs = append(exprs, bson.M{"$in": []interface{}{bson.M{"$dayOfWeek": started_at}, days}})
+	}
+
+	if q.After > 0 || q.Before > 0 {
+		minutes := bson.M{"$add": []interface{}{
+			bson.M{"$multiply": []interface{}{bson.M{"$hour": started_at}, 60}},
+			bson.M{"$minute": started_at},
+		}}
+		after, before := q.minutes()
+
+		from := bson.M{"$gte": []interface{}{minutes, after}}
+		until := bson.M{"$lt": []interface{}{minutes, before}}
+		if after <= before {
+			exprs = append(exprs, from, until)
+		} else {
+			exprs = append(exprs, bson.M{"$or": []interface{}{from, until}})
+		}
+	}
+
+	if len(exprs) > 0 {
+		query["$expr"] = bson.M{"$and": exprs}
+	}
+
+	return query
+}
+
 func (c *MongoCache) PutTrip(username string, trip Trip) {
 	s := c.session.Clone()
 	defer s.Close()
 
-	err := s.DB("").C("trips").Insert(NewCachedTrip(username, trip))
-	if err != nil && !mgo.IsDup(err) {
+	// Replaces the trip on a full sync, the _id is generated on insert
+	selector := bson.M{"username": username, "trip.id": trip.Id}
+	_, err := c.collection(s, "trips").Upsert(selector, bson.M{"$set": bson.M{"username": username, "trip": trip}})
+	if err != nil {
 		log.Println("MongoCache: PUT error -> ", err)
 	}
 }
+
+// CachedHistory records the trip histories that were only partially synced
+type CachedHistory struct {
+	Username   string `bson:"_id"`
+	Incomplete bool   `bson:"incomplete"`
+}
+
+func (c *MongoCache) GetIncomplete(username string) bool {
+	var cached CachedHistory
+
+	s := c.session.Clone()
+	defer s.Close()
+
+	err := c.collection(s, "histories").FindId(username).One(&cached)
+	if err != nil && err != mgo.ErrNotFound {
+		log.Println("MongoCache: GET error -> ", username, err)
+	}
+
+	return cached.Incomplete
+}
+
+func (c *MongoCache) PutIncomplete(username string, incomplete bool) {
+	s := c.session.Clone()
+	defer s.Close()
+
+	_, err := c.collection(s, "histories").UpsertId(username, CachedHistory{username, incomplete})
+	if err != nil {
+		log.Println("MongoCache: PUT error -> ", err)
+	}
+}
+
+type CachedStation struct {
+	Id       uint64           `bson:"_id"`
+	Versions []StationVersion `bson:"versions"`
+}
+
+func (c *MongoCache) GetStationHistory() map[uint64][]StationVersion {
+	var cached []CachedStation
+
+	s := c.session.Clone()
+	defer s.Close()
+
+	history := make(map[uint64][]StationVersion)
+
+	err := c.collection(s, "stations").Find(nil).All(&cached)
+	if err != nil {
+		log.Println("MongoCache: GET error -> stations", err)
+		return history
+	}
+
+	for _, station := range cached {
+		history[station.Id] = station.Versions
+	}
+
+	return history
+}
+
+func (c *MongoCache) PutStationHistory(history map[uint64][]StationVersion) {
+	s := c.session.Clone()
+	defer s.Close()
+
+	for id, versions := range history {
+		_, err := c.collection(s, "stations").UpsertId(id, CachedStation{id, versions})
+		if err != nil {
+			log.Println("MongoCache: PUT error -> ", err)
+		}
+	}
+}
+
+type CachedAlias struct {
+	Label        string `bson:"_id"`
+	StationAlias `bson:",inline"`
+}
+
+func (c *MongoCache) GetAliases() []StationAlias {
+	var cached []CachedAlias
+
+	s := c.session.Clone()
+	defer s.Close()
+
+	err := c.collection(s, "aliases").Find(nil).All(&cached)
+	if err != nil {
+		log.Println("MongoCache: GET error -> aliases", err)
+		return []StationAlias{}
+	}
+
+	aliases := make([]StationAlias, len(cached))
+	for i, alias := range cached {
+		aliases[i] = alias.StationAlias
+	}
+
+	return aliases
+}
+
+func (c *MongoCache) PutAlias(alias StationAlias) {
+	s := c.session.Clone()
+	defer s.Close()
+
+	_, err := c.collection(s, "aliases").UpsertId(alias.Label, CachedAlias{alias.Label, alias})
+	if err != nil {
+		log.Println("MongoCache: PUT error -> ", err)
+	}
+}
+
+func (c *MongoCache) DeleteAlias(label string) {
+	s := c.session.Clone()
+	defer s.Close()
+
+	err := c.collection(s, "aliases").RemoveId(label)
+	if err != nil && err != mgo.ErrNotFound {
+		log.Println("MongoCache: DELETE error -> ", err)
+	}
+}
@@ -4,7 +4,21 @@ type NoopCache struct{}
 
 func (c *NoopCache) GetDistance(route Route) (uint64, bool)   { return 0, false }
 func (c *NoopCache) PutDistance(route Route, distance uint64) {}
+func (c *NoopCache) GetPath(route Route) (Path, bool)         { return nil, false }
+func (c *NoopCache) PutPath(route Route, path Path)           {}
 
 func (c *NoopCache) GetTrip(username, id string) (Trip, bool) { return Trip{}, false }
 func (c *NoopCache) GetTrips(username string) Trips           { return Trips{} }
 func (c *NoopCache) PutTrip(username string, trip Trip)       {}
+func (c *NoopCache) QueryTrips(username string, query TripQuery) Trips {
+	return Trips{}
+}
+func (c *NoopCache) GetIncomplete(username string) bool             { return false }
+func (c *NoopCache) PutIncomplete(username string, incomplete bool) {}
+
+func (c *NoopCache) GetStationHistory() map[uint64][]StationVersion        { return nil }
+func (c *NoopCache) PutStationHistory(history map[uint64][]StationVersion) {}
+
+func (c *NoopCache) GetAliases() []StationAlias  { return nil }
+func (c *NoopCache) PutAlias(alias StationAlias) {}
+func (c *NoopCache) DeleteAlias(label string)    {}
@@ -0,0 +1,254 @@
+package bikage
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/Bowbaq/distance"
+)
+
+// OSMDirectionsAPI routes bicycle trips over a street graph extracted from
+// OpenStreetMap. The graph is a JSON file of the form:
+//
+//	{
+//	  "nodes": [{"id": 42, "lat": 40.7684, "lng": -73.9818, "ele": 12.5}, ...],
+//	  "edges": [{"from": 42, "to": 43, "length": 35.2, "oneway": true}, ...]
+//	}
+//
+// Only bike-legal ways are expected in the extract. Edge lengths are in meters,
+// when missing they are computed from the node coordinates. Node elevations are
+// optional.
+type OSMDirectionsAPI struct {
+	nodes     []osm_node
+	adjacency [][]osm_edge
+
+	snapped map[distance.Coord]int
+	sync.RWMutex
+}
+
+type osm_node struct {
+	id    int64
+	coord distance.Coord
+	ele   *float64
+}
+
+// point places the elevation of the node, if known, at the coordinates
+func (n osm_node) point(coord distance.Coord) Point {
+	p := Point{Lat: coord.Lat, Lng: coord.Lng}
+	if n.ele != nil {
+		p.Ele, p.HasEle = *n.ele, true
+	}
+
+	return p
+}
+
+type osm_edge struct {
+	to     int
+	length float64
+}
+
+type osm_graph struct {
+	Nodes []struct {
+		Id  int64    `json:"id"`
+		Lat float64  `json:"lat"`
+		Lng float64  `json:"lng"`
+		Ele *float64 `json:"ele"`
+	} `json:"nodes"`
+	Edges []struct {
+		From   int64   `json:"from"`
+		To     int64   `json:"to"`
+		Length float64 `json:"length"`
+		Oneway bool    `json:"oneway"`
+	} `json:"edges"`
+}
+
+func NewOSMDirectionsAPI(graph_path string) (*OSMDirectionsAPI, error) {
+	f, err := os.Open(graph_path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var graph osm_graph
+	if err := json.NewDecoder(f).Decode(&graph); err != nil {
+		return nil, err
+	}
+	if len(graph.Nodes) == 0 {
+		return nil, errors.New("OSM graph has no nodes")
+	}
+
+	api := &OSMDirectionsAPI{
+		nodes:     make([]osm_node, len(graph.Nodes)),
+		adjacency: make([][]osm_edge, len(graph.Nodes)),
+		snapped:   make(map[distance.Coord]int),
+	}
+
+	index := make(map[int64]int, len(graph.Nodes))
+	for i, n := range graph.Nodes {
+		api.nodes[i] = osm_node{n.Id, distance.Coord{Lat: n.Lat, Lng: n.Lng}, n.Ele}
+		index[n.Id] = i
+	}
+
+	for _, e := range graph.Edges {
+		from, fok := index[e.From]
+		to, tok := index[e.To]
+		if !fok || !tok {
+			return nil, fmt.Errorf("OSM graph edge %d -> %d references an unknown node", e.From, e.To)
+		}
+
+		length := e.Length
+		if length <= 0 {
+			length = haversine(api.nodes[from].coord, api.nodes[to].coord)
+		}
+
+		api.adjacency[from] = append(api.adjacency[from], osm_edge{to, length})
+		if !e.Oneway {
+			api.adjacency[to] = append(api.adjacency[to], osm_edge{from, length})
+		}
+	}
+
+	return api, nil
+}
+
+func (api *OSMDirectionsAPI) GetDistance(trip distance.Trip) (uint64, error) {
+	length, _, err := api.GetPath(trip)
+	return length, err
+}
+
+// GetPath returns the length of the shortest route in meters, along with its
+// geometry.
+func (api *OSMDirectionsAPI) GetPath(trip distance.Trip) (uint64, Path, error) {
+	if trip.Mode != distance.Bicycling {
+		return 0, nil, fmt.Errorf("OSMDirectionsAPI only supports bicycling, got %v", trip.Mode)
+	}
+
+	from, to := api.snap(trip.From), api.snap(trip.To)
+
+	nodes, length, err := api.shortest_path(from, to)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Account for the ride between the stations and the street graph
+	length += haversine(trip.From, api.nodes[from].coord) + haversine(api.nodes[to].coord, trip.To)
+
+	path := make(Path, 0, len(nodes)+2)
+	if trip.From != api.nodes[from].coord {
+		path = append(path, api.nodes[from].point(trip.From))
+	}
+	for _, n := range nodes {
+		path = append(path, api.nodes[n].point(api.nodes[n].coord))
+	}
+	if trip.To != api.nodes[to].coord {
+		path = append(path, api.nodes[to].point(trip.To))
+	}
+
+	return uint64(math.Round(length)), path, nil
+}
+
+func (api *OSMDirectionsAPI) GetDistances(trips []distance.Trip) map[distance.Trip]uint64 {
+	result := make(map[distance.Trip]uint64)
+
+	for _, trip := range trips {
+		if _, ok := result[trip]; ok {
+			continue
+		}
+
+		dist, err := api.GetDistance(trip)
+		if err != nil {
+			log.Println("OSMDirectionsAPI GET error ->", err)
+			continue
+		}
+		result[trip] = dist
+	}
+
+	return result
+}
+
+// snap returns the index of the graph node closest to coord
+func (api *OSMDirectionsAPI) snap(coord distance.Coord) int {
+	api.RLock()
+	node, ok := api.snapped[coord]
+	api.RUnlock()
+	if ok {
+		return node
+	}
+
+	best := math.Inf(1)
+	for i, n := range api.nodes {
+		if d := haversine(coord, n.coord); d < best {
+			best, node = d, i
+		}
+	}
+
+	api.Lock()
+	api.snapped[coord] = node
+	api.Unlock()
+
+	return node
+}
+
+// shortest_path runs A* between two node indexes, using the straight line
+// distance to the destination as the heuristic.
+func (api *OSMDirectionsAPI) shortest_path(from, to int) ([]int, float64, error) {
+	dist := make(map[int]float64)
+	prev := make(map[int]int)
+	done := make(map[int]bool)
+
+	dist[from] = 0
+	queue := &osm_queue{{from, haversine(api.nodes[from].coord, api.nodes[to].coord)}}
+
+	for queue.Len() > 0 {
+		current := heap.Pop(queue).(osm_queue_item).node
+		if current == to {
+			path := []int{to}
+			for n := to; n != from; {
+				n = prev[n]
+				path = append([]int{n}, path...)
+			}
+			return path, dist[to], nil
+		}
+
+		if done[current] {
+			continue
+		}
+		done[current] = true
+
+		for _, edge := range api.adjacency[current] {
+			candidate := dist[current] + edge.length
+			if known, ok := dist[edge.to]; ok && known <= candidate {
+				continue
+			}
+
+			dist[edge.to] = candidate
+			prev[edge.to] = current
+			heap.Push(queue, osm_queue_item{edge.to, candidate + haversine(api.nodes[edge.to].coord, api.nodes[to].coord)})
+		}
+	}
+
+	return nil, 0, fmt.Errorf("no bicycle route between OSM nodes %d and %d", api.nodes[from].id, api.nodes[to].id)
+}
+
+type osm_queue_item struct {
+	node     int
+	priority float64
+}
+
+type osm_queue []osm_queue_item
+
+func (q osm_queue) Len() int            { return len(q) }
+func (q osm_queue) Less(i, j int) bool  { return q[i].priority < q[j].priority }
+func (q osm_queue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *osm_queue) Push(x interface{}) { *q = append(*q, x.(osm_queue_item)) }
+func (q *osm_queue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,97 @@
+package bikage_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+
+	. "github.com/Bowbaq/bikage"
+	"github.com/Bowbaq/distance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Three nodes in a line: A -(100m)- B -(100m)- C, plus a 500m edge between A
+// and C. B -> A is oneway only. D isn't connected.
+const test_osm_graph = `{
+  "nodes": [
+    {"id": 1, "lat": 40.0, "lng": -73.0, "ele": 10},
+    {"id": 2, "lat": 40.001, "lng": -73.0, "ele": 12},
+    {"id": 3, "lat": 40.002, "lng": -73.0},
+    {"id": 4, "lat": 41.0, "lng": -73.0}
+  ],
+  "edges": [
+    {"from": 2, "to": 1, "length": 100, "oneway": true},
+    {"from": 2, "to": 3, "length": 100},
+    {"from": 1, "to": 3, "length": 500}
+  ]
+}`
+
+var _ = Describe("OSMDirectionsAPI", func() {
+	var api *OSMDirectionsAPI
+
+	a := distance.Coord{Lat: 40.0, Lng: -73.0}
+	c := distance.Coord{Lat: 40.002, Lng: -73.0}
+	d := distance.Coord{Lat: 41.0, Lng: -73.0}
+
+	BeforeEach(func() {
+		f, err := ioutil.TempFile("", "osm-graph")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.Remove(f.Name())
+
+		f.WriteString(test_osm_graph)
+		f.Close()
+
+		api, err = NewOSMDirectionsAPI(f.Name())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("finds the shortest path", func() {
+		dist, err := api.GetDistance(distance.Trip{From: c, To: a, Mode: distance.Bicycling})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dist).To(BeNumerically("==", 200))
+	})
+
+	It("doesn't ride oneway edges backwards", func() {
+		dist, err := api.GetDistance(distance.Trip{From: a, To: c, Mode: distance.Bicycling})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dist).To(BeNumerically("==", 500))
+	})
+
+	It("fails when the nodes aren't connected", func() {
+		_, err := api.GetDistance(distance.Trip{From: a, To: d, Mode: distance.Bicycling})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("only supports bicycling", func() {
+		_, err := api.GetDistance(distance.Trip{From: a, To: c})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns the geometry of the route", func() {
+		_, path, err := api.GetPath(distance.Trip{From: c, To: a, Mode: distance.Bicycling})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(path).To(Equal(Path{
+			{Lat: 40.002, Lng: -73.0},
+			{Lat: 40.001, Lng: -73.0, Ele: 12, HasEle: true},
+			{Lat: 40.0, Lng: -73.0, Ele: 10, HasEle: true},
+		}))
+	})
+
+	It("omits trips it can't route from GetDistances()", func() {
+		routable := distance.Trip{From: c, To: a, Mode: distance.Bicycling}
+		distances := api.GetDistances([]distance.Trip{routable, {From: a, To: d, Mode: distance.Bicycling}})
+		Expect(distances).To(HaveLen(1))
+		Expect(distances).To(HaveKeyWithValue(routable, BeNumerically("==", 200)))
+	})
+
+	It("gets the trips it can't route a straight path through the RouteAPI", func() {
+		from := Station{Id: 1, Label: "C", Lat: c.Lat, Lng: c.Lng}
+		to := Station{Id: 2, Label: "D", Lat: d.Lat, Lng: d.Lng}
+		trip := Trip{Id: "1", Route: Route{From: from, To: to}}
+
+		routes := NewRouteAPI(api).GetAllRoutes(context.Background(), Trips{trip})
+		Expect(routes).To(Equal([]RoutedTrip{{Trip: trip, Path: Path{{Lat: c.Lat, Lng: c.Lng}, {Lat: d.Lat, Lng: d.Lng}}}}))
+	})
+})
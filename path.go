@@ -0,0 +1,73 @@
+package bikage
+
+import (
+	"math"
+	"strings"
+
+	"github.com/Bowbaq/distance"
+)
+
+// Point is a location along a route. Ele is the elevation in meters, when
+// HasEle is set.
+type Point struct {
+	Lat    float64
+	Lng    float64
+	Ele    float64 `json:",omitempty" bson:",omitempty"`
+	HasEle bool    `json:",omitempty" bson:",omitempty"`
+}
+
+// Elevation returns the elevation in meters, false if it isn't known. The paths
+// cached without HasEle have an elevation unless it is 0.
+func (p Point) Elevation() (float64, bool) {
+	return p.Ele, p.HasEle || p.Ele != 0
+}
+
+// DistanceTo returns the great-circle distance to q in meters, elevation is
+// ignored.
+func (p Point) DistanceTo(q Point) float64 {
+	return haversine(distance.Coord{Lat: p.Lat, Lng: p.Lng}, distance.Coord{Lat: q.Lat, Lng: q.Lng})
+}
+
+// Path is the geometry of a route, from the start station to the end station.
+type Path []Point
+
+// StraightPath is the path going directly from the start to the end station of
+// a route.
+func StraightPath(route Route) Path {
+	return Path{
+		{Lat: route.From.Lat, Lng: route.From.Lng},
+		{Lat: route.To.Lat, Lng: route.To.Lng},
+	}
+}
+
+// Polyline encodes the path with Google's polyline algorithm, elevation is
+// discarded.
+func (p Path) Polyline() string {
+	var buf strings.Builder
+
+	var prev_lat, prev_lng int64
+	for _, point := range p {
+		lat := int64(math.Round(point.Lat * 1e5))
+		lng := int64(math.Round(point.Lng * 1e5))
+
+		encode_polyline_value(&buf, lat-prev_lat)
+		encode_polyline_value(&buf, lng-prev_lng)
+
+		prev_lat, prev_lng = lat, lng
+	}
+
+	return buf.String()
+}
+
+func encode_polyline_value(buf *strings.Builder, value int64) {
+	value <<= 1
+	if value < 0 {
+		value = ^value
+	}
+
+	for value >= 0x20 {
+		buf.WriteByte(byte((0x20 | (value & 0x1f)) + 63))
+		value >>= 5
+	}
+	buf.WriteByte(byte(value + 63))
+}
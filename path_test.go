@@ -0,0 +1,24 @@
+package bikage_test
+
+import (
+	. "github.com/Bowbaq/bikage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Path", func() {
+	Describe("Polyline()", func() {
+		It("encodes the path with Google's polyline algorithm", func() {
+			path := Path{{Lat: 38.5, Lng: -120.2}, {Lat: 40.7, Lng: -120.95}, {Lat: 43.252, Lng: -126.453}}
+			Expect(path.Polyline()).To(Equal("_p~iF~ps|U_ulLnnqC_mqNvxq`@"))
+		})
+	})
+
+	Describe("StraightPath()", func() {
+		It("goes from the start station to the end station", func() {
+			route := Route{From: Station{Lat: 40.1, Lng: -73.1}, To: Station{Lat: 40.2, Lng: -73.2}}
+			Expect(StraightPath(route)).To(Equal(Path{{Lat: 40.1, Lng: -73.1}, {Lat: 40.2, Lng: -73.2}}))
+		})
+	})
+})
@@ -0,0 +1,156 @@
+package bikage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RetryPolicy retries transient failures: network errors, 429 and 5xx
+// responses, and the Directions API statuses asking to try again later.
+// Attempts are spaced by a random delay of up to BaseDelay * 2^attempt, capped
+// at MaxDelay.
+type RetryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:  4,
+	BaseDelay: 500 * time.Millisecond,
+	MaxDelay:  10 * time.Second,
+}
+
+// http_timeout bounds each request of the http clients created by bikage
+const http_timeout = 30 * time.Second
+
+// Do calls f until it succeeds, fails with a permanent error, runs out of
+// attempts or ctx is done
+func (p RetryPolicy) Do(ctx context.Context, f func() error) error {
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := f()
+		if err == nil || attempt >= p.Attempts || !is_transient(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.delay(attempt)):
+		}
+	}
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	max := p.BaseDelay << uint(attempt-1)
+	if max <= 0 || max > p.MaxDelay {
+		max = p.MaxDelay
+	}
+	if max <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(max))) + 1
+}
+
+// get sends a GET request, retrying transient failures
+func (p RetryPolicy) get(ctx context.Context, client *http.Client, location string) (*http.Response, error) {
+	return p.send(ctx, client, func() (*http.Request, error) {
+		return http.NewRequest("GET", location, nil)
+	})
+}
+
+// send_once doesn't retry, for the requests that can't be sent twice
+var send_once = RetryPolicy{Attempts: 1}
+
+// post_form sends a form, retrying transient failures
+func (p RetryPolicy) post_form(ctx context.Context, client *http.Client, location string, values url.Values) (*http.Response, error) {
+	return p.send(ctx, client, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", location, strings.NewReader(values.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		return req, nil
+	})
+}
+
+// send builds and sends the request until it gets a response below 400
+func (p RetryPolicy) send(ctx context.Context, client *http.Client, new_request func() (*http.Request, error)) (*http.Response, error) {
+	var resp *http.Response
+
+	err := p.Do(ctx, func() error {
+		req, err := new_request()
+		if err != nil {
+			return err
+		}
+
+		resp, err = client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode >= 400 {
+			resp.Body.Close()
+			return &status_error{req.Method, req.URL.String(), resp.StatusCode, resp.Status}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// status_error is returned for responses with an error status
+type status_error struct {
+	method string
+	url    string
+	code   int
+	status string
+}
+
+func (e *status_error) Error() string {
+	return fmt.Sprintf("%s %s -> %s", e.method, e.url, e.status)
+}
+
+// Is makes 429 responses match ErrRateLimited
+func (e *status_error) Is(target error) bool {
+	return target == ErrRateLimited && e.code == http.StatusTooManyRequests
+}
+
+func is_transient(err error) bool {
+	var status *status_error
+	if errors.As(err, &status) {
+		return status.code == http.StatusTooManyRequests || status.code >= 500
+	}
+
+	// url.Error wraps every client error, look at the cause
+	var url_err *url.Error
+	if errors.As(err, &url_err) {
+		err = url_err.Err
+	}
+
+	var net_err net.Error
+	if errors.As(err, &net_err) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	message := err.Error()
+	return strings.Contains(message, "OVER_QUERY_LIMIT") || strings.Contains(message, "UNKNOWN_ERROR")
+}
@@ -0,0 +1,64 @@
+package bikage_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/Bowbaq/bikage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RetryPolicy", func() {
+	policy := RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+	It("retries transient errors until the attempts run out", func() {
+		calls := 0
+		err := policy.Do(context.Background(), func() error {
+			calls++
+			return errors.New("OVER_QUERY_LIMIT")
+		})
+
+		Expect(err).To(MatchError("OVER_QUERY_LIMIT"))
+		Expect(calls).To(Equal(3))
+	})
+
+	It("stops once the call succeeds", func() {
+		calls := 0
+		err := policy.Do(context.Background(), func() error {
+			calls++
+			if calls == 1 {
+				return errors.New("UNKNOWN_ERROR")
+			}
+			return nil
+		})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(calls).To(Equal(2))
+	})
+
+	It("doesn't retry permanent errors", func() {
+		calls := 0
+		err := policy.Do(context.Background(), func() error {
+			calls++
+			return errors.New("ZERO_RESULTS")
+		})
+
+		Expect(err).To(HaveOccurred())
+		Expect(calls).To(Equal(1))
+	})
+
+	It("doesn't call once the context is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := policy.Do(ctx, func() error {
+			Fail("unexpected call")
+			return nil
+		})
+
+		Expect(err).To(Equal(context.Canceled))
+	})
+})
@@ -1,28 +1,47 @@
 package bikage
 
-import "github.com/Bowbaq/distance"
+import (
+	"context"
+	"log"
+
+	"github.com/Bowbaq/distance"
+)
 
 type DirectionsAPI interface {
 	GetDistance(trip distance.Trip) (uint64, error)
 	GetDistances(trips []distance.Trip) map[distance.Trip]uint64
 }
 
+// PathDirectionsAPI is implemented by the DirectionsAPIs able to return the
+// geometry of the routes they compute.
+type PathDirectionsAPI interface {
+	DirectionsAPI
+	GetPath(trip distance.Trip) (uint64, Path, error)
+}
+
 type RouteAPI interface {
 	WithCache(cache DistanceCache) RouteAPI
 
-	Get(trip Trip) (uint64, error)
-	GetAll(trips Trips) map[Trip]uint64
+	Get(ctx context.Context, trip Trip) (uint64, error)
+	GetAll(ctx context.Context, trips Trips) map[Trip]uint64
+
+	GetRoute(ctx context.Context, trip Trip) (RoutedTrip, error)
+	// GetAllRoutes returns every trip, the ones that can't be routed have a
+	// zero Distance and a StraightPath
+	GetAllRoutes(ctx context.Context, trips Trips) []RoutedTrip
 }
 
 type route_api struct {
 	cache DistanceCache
 	api   DirectionsAPI
+	retry RetryPolicy
 }
 
 func NewRouteAPI(directions_api DirectionsAPI) RouteAPI {
 	return &route_api{
 		cache: new(NoopCache),
 		api:   directions_api,
+		retry: DefaultRetryPolicy,
 	}
 }
 
@@ -31,13 +50,13 @@ func (ra *route_api) WithCache(cache DistanceCache) RouteAPI {
 	return ra
 }
 
-func (ra *route_api) Get(trip Trip) (uint64, error) {
+func (ra *route_api) Get(ctx context.Context, trip Trip) (uint64, error) {
 	distance, ok := ra.cache.GetDistance(trip.Route)
 	if ok {
 		return distance, nil
 	}
 
-	distance, err := ra.calculate(trip.Route)
+	distance, err := ra.calculate(ctx, trip.Route)
 	if err != nil {
 		return 0, err
 	}
@@ -45,7 +64,7 @@ func (ra *route_api) Get(trip Trip) (uint64, error) {
 	return distance, nil
 }
 
-func (ra *route_api) GetAll(trips Trips) map[Trip]uint64 {
+func (ra *route_api) GetAll(ctx context.Context, trips Trips) map[Trip]uint64 {
 	result := make(map[Trip]uint64)
 
 	var misses Trips
@@ -57,18 +76,86 @@ func (ra *route_api) GetAll(trips Trips) map[Trip]uint64 {
 		}
 	}
 
-	for k, v := range ra.calculate_all(misses) {
+	for k, v := range ra.calculate_all(ctx, misses) {
 		result[k] = v
 	}
 
 	return result
 }
 
-func (ra *route_api) calculate(route Route) (uint64, error) {
-	distance, err := ra.api.GetDistance(distance.Trip{
-		From: route.From.Coord(),
-		To:   route.To.Coord(),
-		Mode: distance.Bicycling,
+func (ra *route_api) GetRoute(ctx context.Context, trip Trip) (RoutedTrip, error) {
+	distance, err := ra.Get(ctx, trip)
+	if err != nil {
+		return RoutedTrip{}, err
+	}
+
+	return RoutedTrip{trip, distance, ra.path(ctx, trip.Route)}, nil
+}
+
+func (ra *route_api) GetAllRoutes(ctx context.Context, trips Trips) []RoutedTrip {
+	distances := ra.GetAll(ctx, trips)
+
+	var result []RoutedTrip
+	for _, trip := range trips {
+		if distance, ok := distances[trip]; ok {
+			result = append(result, RoutedTrip{trip, distance, ra.path(ctx, trip.Route)})
+		} else {
+			result = append(result, RoutedTrip{trip, 0, StraightPath(trip.Route)})
+		}
+	}
+
+	return result
+}
+
+// path returns the geometry of the route, or nil when the directions api
+// can't provide it.
+func (ra *route_api) path(ctx context.Context, route Route) Path {
+	if path, ok := ra.cache.GetPath(route); ok {
+		return path
+	}
+
+	api, ok := ra.api.(PathDirectionsAPI)
+	if !ok {
+		return nil
+	}
+
+	var distance uint64
+	var path Path
+	err := ra.retry.Do(ctx, func() (err error) {
+		distance, path, err = api.GetPath(directions_request(route))
+		return err
+	})
+	if err != nil {
+		log.Println("RouteAPI PATH error ->", route, err)
+		return nil
+	}
+
+	ra.cache.PutDistance(route, distance)
+	ra.cache.PutPath(route, path)
+
+	return path
+}
+
+func (ra *route_api) calculate(ctx context.Context, route Route) (uint64, error) {
+	if api, ok := ra.api.(PathDirectionsAPI); ok {
+		var distance uint64
+		var path Path
+		err := ra.retry.Do(ctx, func() (err error) {
+			distance, path, err = api.GetPath(directions_request(route))
+			return err
+		})
+		if err == nil {
+			ra.cache.PutDistance(route, distance)
+			ra.cache.PutPath(route, path)
+		}
+
+		return distance, err
+	}
+
+	var distance uint64
+	err := ra.retry.Do(ctx, func() (err error) {
+		distance, err = ra.api.GetDistance(directions_request(route))
+		return err
 	})
 
 	if err == nil {
@@ -78,27 +165,54 @@ func (ra *route_api) calculate(route Route) (uint64, error) {
 	return distance, err
 }
 
-func (ra *route_api) calculate_all(trips Trips) map[Trip]uint64 {
-	trip_for_request := make(map[distance.Trip]Trip)
+func (ra *route_api) calculate_all(ctx context.Context, trips Trips) map[Trip]uint64 {
+	result := make(map[Trip]uint64)
+
+	// Routing one trip at a time gets the geometry cached along the way
+	if _, ok := ra.api.(PathDirectionsAPI); ok {
+		for _, trip := range trips {
+			if ctx.Err() != nil {
+				break
+			}
+			if distance, err := ra.Get(ctx, trip); err == nil {
+				result[trip] = distance
+			}
+		}
+
+		return result
+	}
+
+	// The batched requests can't be cancelled once sent
+	if ctx.Err() != nil {
+		return result
+	}
+
+	trips_for_request := make(map[distance.Trip]Trips)
 	var requests []distance.Trip
 	for _, trip := range trips {
-		request := distance.Trip{
-			From: trip.Route.From.Coord(),
-			To:   trip.Route.To.Coord(),
-			Mode: distance.Bicycling,
+		request := directions_request(trip.Route)
+		if _, ok := trips_for_request[request]; !ok {
+			requests = append(requests, request)
 		}
-		requests = append(requests, request)
-		trip_for_request[request] = trip
+		trips_for_request[request] = append(trips_for_request[request], trip)
 	}
 
 	distances := ra.api.GetDistances(requests)
 
-	result := make(map[Trip]uint64)
 	for request, distance := range distances {
-		trip := trip_for_request[request]
-		result[trip] = distance
-		ra.cache.PutDistance(trip.Route, distance)
+		for _, trip := range trips_for_request[request] {
+			result[trip] = distance
+			ra.cache.PutDistance(trip.Route, distance)
+		}
 	}
 
 	return result
 }
+
+func directions_request(route Route) distance.Trip {
+	return distance.Trip{
+		From: route.From.Coord(),
+		To:   route.To.Coord(),
+		Mode: distance.Bicycling,
+	}
+}
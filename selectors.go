@@ -0,0 +1,67 @@
+package bikage
+
+import (
+	"github.com/PuerkitoBio/goquery"
+)
+
+// SelectorProfile holds the CSS selectors the member portal pages are read
+// with. Each redesign of the portal gets a new profile.
+type SelectorProfile struct {
+	Name string
+
+	// Login form and profile menu
+	CSRF      string
+	TripsLink string
+
+	// Trip history pages, the trip fields are relative to TripRow
+	TripsTable   string
+	TripRow      string
+	StartDate    string
+	StartStation string
+	EndDate      string
+	EndStation   string
+	NextPage     string
+	LastPage     string
+}
+
+// SelectorProfiles are tried in order, the most recent layout comes first
+var SelectorProfiles = []SelectorProfile{
+	{
+		Name: "ed-2019",
+
+		CSRF:      `#loginPopupId input[name="_login_csrf_security_token"]`,
+		TripsLink: ".ed-profile-menu__link_trips a",
+
+		TripsTable:   ".ed-table__items",
+		TripRow:      ".ed-table__items .ed-table__item_trip",
+		StartDate:    ".ed-table__item__info__sub-info_trip-start-date",
+		StartStation: ".ed-table__item__info__sub-info_trip-start-station",
+		EndDate:      ".ed-table__item__info__sub-info_trip-end-date",
+		EndStation:   ".ed-table__item__info__sub-info_trip-end-station",
+		NextPage:     ".ed-paginated-navigation__pages-group__link_next",
+		LastPage:     ".ed-paginated-navigation__pages-group__link_last",
+	},
+}
+
+// find_attr returns the attribute of the first element matching the selector
+// of a profile, along with the profile
+func find_attr(doc *goquery.Document, selector func(SelectorProfile) string, attr string) (string, *SelectorProfile, bool) {
+	for i := range SelectorProfiles {
+		if value, ok := doc.Find(selector(SelectorProfiles[i])).Attr(attr); ok {
+			return value, &SelectorProfiles[i], true
+		}
+	}
+
+	return "", nil, false
+}
+
+// match_trips_page returns the first profile able to read the trip history page
+func match_trips_page(doc *goquery.Document) (*SelectorProfile, bool) {
+	for i := range SelectorProfiles {
+		if doc.Find(SelectorProfiles[i].TripsTable).Length() > 0 {
+			return &SelectorProfiles[i], true
+		}
+	}
+
+	return nil, false
+}
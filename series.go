@@ -0,0 +1,203 @@
+package bikage
+
+import (
+	"sort"
+	"time"
+)
+
+// DateFormat is the format dates are written and parsed in, e.g. in JSON
+const DateFormat = "2006-01-02"
+
+// Date is a calendar day, independent of any time zone
+type Date struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// DateOf returns the day of t in the zone of t
+func DateOf(t time.Time) Date {
+	year, month, day := t.Date()
+	return Date{year, month, day}
+}
+
+func ParseDate(value string) (Date, error) {
+	t, err := time.Parse(DateFormat, value)
+	if err != nil {
+		return Date{}, err
+	}
+
+	return DateOf(t), nil
+}
+
+// Time returns midnight of the day in location
+func (d Date) Time(location *time.Location) time.Time {
+	return time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, location)
+}
+
+func (d Date) AddDays(days int) Date {
+	return DateOf(d.Time(time.UTC).AddDate(0, 0, days))
+}
+
+func (d Date) Before(other Date) bool {
+	return d.Time(time.UTC).Before(other.Time(time.UTC))
+}
+
+func (d Date) IsZero() bool {
+	return d == Date{}
+}
+
+func (d Date) String() string {
+	return d.Time(time.UTC).Format(DateFormat)
+}
+
+func (d Date) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Date) UnmarshalText(text []byte) error {
+	date, err := ParseDate(string(text))
+	if err != nil {
+		return err
+	}
+	*d = date
+
+	return nil
+}
+
+// Period is the length of the buckets of a Series
+type Period string
+
+const (
+	PeriodDay   Period = "day"
+	PeriodWeek  Period = "week" // weeks start on Monday
+	PeriodMonth Period = "month"
+	PeriodYear  Period = "year"
+)
+
+// Start returns the first day of the period d belongs to
+func (p Period) Start(d Date) Date {
+	switch p {
+	case PeriodWeek:
+		// Monday is 0
+		weekday := (int(d.Time(time.UTC).Weekday()) + 6) % 7
+		return d.AddDays(-weekday)
+	case PeriodMonth:
+		return Date{d.Year, d.Month, 1}
+	case PeriodYear:
+		return Date{d.Year, time.January, 1}
+	}
+
+	return d
+}
+
+// Bucket sums the trips started during a period
+type Bucket struct {
+	Start    Date
+	Distance uint64 // meters
+	Duration time.Duration
+	Trips    int
+
+	// SpeedDistance is the distance of the trips counted in the speed, outliers
+	// are left out, see MinTripSpeed. SpeedSum adds the speed of each of these
+	// trips in km/h weighted by its distance.
+	SpeedDistance uint64
+	SpeedSum      float64
+}
+
+func (b Bucket) Km() float64 {
+	return km_dist(b.Distance)
+}
+
+func (b Bucket) Mi() float64 {
+	return mi_dist(b.Distance)
+}
+
+// Speed returns the average speed of the trips in km/h, weighted by their
+// distance
+func (b Bucket) Speed() float64 {
+	if b.SpeedDistance == 0 {
+		return 0
+	}
+
+	return b.SpeedSum / float64(b.SpeedDistance)
+}
+
+func (b Bucket) add(other Bucket) Bucket {
+	b.Distance += other.Distance
+	b.Duration += other.Duration
+	b.Trips += other.Trips
+	b.SpeedDistance += other.SpeedDistance
+	b.SpeedSum += other.SpeedSum
+
+	return b
+}
+
+// Series holds buckets sorted by start, periods without trips are left out
+type Series []Bucket
+
+// Add counts a trip on day, it returns the updated series
+func (s Series) Add(day Date, distance uint64, duration time.Duration) Series {
+	trip := Bucket{Start: day, Distance: distance, Duration: duration, Trips: 1}
+	if speed, outlier := trip_speed(distance, duration); !outlier {
+		trip.SpeedDistance = distance
+		trip.SpeedSum = speed * float64(distance)
+	}
+
+	i := s.search(day)
+	if i < len(s) && s[i].Start == day {
+		s[i] = s[i].add(trip)
+		return s
+	}
+
+	s = append(s, Bucket{})
+	copy(s[i+1:], s[i:])
+	s[i] = trip
+
+	return s
+}
+
+// Get returns the bucket starting on day
+func (s Series) Get(day Date) (Bucket, bool) {
+	if i := s.search(day); i < len(s) && s[i].Start == day {
+		return s[i], true
+	}
+
+	return Bucket{}, false
+}
+
+func (s Series) search(day Date) int {
+	return sort.Search(len(s), func(i int) bool {
+		return !s[i].Start.Before(day)
+	})
+}
+
+// By aggregates the series into buckets of period, the series must be daily or
+// finer than period
+func (s Series) By(period Period) Series {
+	aggregated := make(Series, 0)
+	for _, bucket := range s {
+		start := period.Start(bucket.Start)
+
+		last := len(aggregated) - 1
+		if last >= 0 && aggregated[last].Start == start {
+			aggregated[last] = aggregated[last].add(bucket)
+			continue
+		}
+
+		bucket.Start = start
+		aggregated = append(aggregated, bucket)
+	}
+
+	return aggregated
+}
+
+// Total sums the buckets of the series
+func (s Series) Total() Bucket {
+	var total Bucket
+	for _, bucket := range s {
+		total = total.add(bucket)
+	}
+
+	return total
+}
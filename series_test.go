@@ -0,0 +1,104 @@
+package bikage_test
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/Bowbaq/bikage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Series", func() {
+	// Sunday, the last day of a week and of a year
+	sunday := Date{2017, time.December, 31}
+	monday := Date{2018, time.January, 1}
+	friday := Date{2018, time.January, 5}
+
+	var series Series
+
+	BeforeEach(func() {
+		series = Series{}
+		series = series.Add(friday, 3000, 15*time.Minute)
+		series = series.Add(sunday, 1000, 6*time.Minute)
+		series = series.Add(monday, 2000, 10*time.Minute)
+		series = series.Add(friday, 1000, 5*time.Minute)
+	})
+
+	Describe("Add()", func() {
+		It("keeps one bucket per day, sorted", func() {
+			Expect(series).To(HaveLen(3))
+			Expect(series[0].Start).To(Equal(sunday))
+			Expect(series[1].Start).To(Equal(monday))
+			Expect(series[2].Start).To(Equal(friday))
+			Expect(series[2].Distance).To(BeNumerically("==", 4000))
+			Expect(series[2].Duration).To(Equal(20 * time.Minute))
+			Expect(series[2].Trips).To(Equal(2))
+		})
+	})
+
+	Describe("Get()", func() {
+		It("returns the bucket of the day", func() {
+			bucket, ok := series.Get(monday)
+			Expect(ok).To(BeTrue())
+			Expect(bucket.Distance).To(BeNumerically("==", 2000))
+
+			_, ok = series.Get(monday.AddDays(1))
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("By()", func() {
+		It("aggregates into weeks starting on Monday", func() {
+			weeks := series.By(PeriodWeek)
+			Expect(weeks).To(HaveLen(2))
+			Expect(weeks[0].Start).To(Equal(Date{2017, time.December, 25}))
+			Expect(weeks[0].Distance).To(BeNumerically("==", 1000))
+			Expect(weeks[1].Start).To(Equal(monday))
+			Expect(weeks[1].Distance).To(BeNumerically("==", 6000))
+			Expect(weeks[1].Duration).To(Equal(30 * time.Minute))
+			Expect(weeks[1].Trips).To(Equal(3))
+		})
+
+		It("aggregates into months and years", func() {
+			Expect(series.By(PeriodMonth)).To(HaveLen(2))
+			Expect(series.By(PeriodMonth)[0].Start).To(Equal(Date{2017, time.December, 1}))
+
+			years := series.By(PeriodYear)
+			Expect(years).To(HaveLen(2))
+			Expect(years[1].Start).To(Equal(monday))
+			Expect(years[1].Trips).To(Equal(3))
+		})
+	})
+
+	Describe("Bucket.Speed()", func() {
+		It("returns the average speed of the trips weighted by their distance", func() {
+			// 1 km at 10 km/h, 6 km at 12 km/h
+			Expect(series.Total().Speed()).To(BeNumerically("~", 11.71, 0.01))
+			Expect(Bucket{}.Speed()).To(BeZero())
+		})
+
+		It("leaves out idle and implausibly fast trips", func() {
+			series = series.Add(monday, 1000, 2*time.Hour)
+			series = series.Add(monday, 10000, 5*time.Minute)
+
+			bucket, _ := series.Get(monday)
+			Expect(bucket.Trips).To(Equal(3))
+			Expect(bucket.Distance).To(BeNumerically("==", 13000))
+			Expect(bucket.Speed()).To(BeNumerically("~", 12, 0.01))
+		})
+	})
+
+	Describe("Date", func() {
+		It("is written as yyyy-mm-dd in JSON", func() {
+			data, err := json.Marshal(sunday)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(`"2017-12-31"`))
+
+			var date Date
+			Expect(json.Unmarshal(data, &date)).To(Succeed())
+			Expect(date).To(Equal(sunday))
+		})
+	})
+})
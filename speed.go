@@ -0,0 +1,58 @@
+package bikage
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Trips slower than MinTripSpeed or faster than MaxTripSpeed are left out of
+// the speeds. The slow ones are bikes kept out of the dock while idle, or
+// round trips, the fast ones are parsing or routing errors.
+const (
+	MinTripSpeed = 3.0  // km/h
+	MaxTripSpeed = 45.0 // km/h
+)
+
+// trip_speed returns the speed of a trip in km/h, and whether it is an outlier
+func trip_speed(distance uint64, duration time.Duration) (float64, bool) {
+	if duration <= 0 {
+		return 0, true
+	}
+
+	speed := km_dist(distance) / duration.Hours()
+	return speed, speed < MinTripSpeed || speed > MaxTripSpeed
+}
+
+// SpeedDistribution summarizes the speed of the trips in km/h, outliers
+// excluded
+type SpeedDistribution struct {
+	Median float64
+	P90    float64
+	Max    float64
+
+	Trips    int
+	Outliers int
+}
+
+func new_speed_distribution(speeds []float64, outliers int) SpeedDistribution {
+	sort.Float64s(speeds)
+
+	distribution := SpeedDistribution{Trips: len(speeds), Outliers: outliers}
+	if len(speeds) > 0 {
+		distribution.Median = percentile(speeds, 0.5)
+		distribution.P90 = percentile(speeds, 0.9)
+		distribution.Max = speeds[len(speeds)-1]
+	}
+
+	return distribution
+}
+
+// percentile interpolates between the closest ranks of sorted
+func percentile(sorted []float64, p float64) float64 {
+	rank := p * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	return sorted[lower] + (rank-float64(lower))*(sorted[upper]-sorted[lower])
+}
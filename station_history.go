@@ -0,0 +1,209 @@
+package bikage
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// StationResolver finds the station a trip history label refers to, as of the
+// time of the trip.
+type StationResolver interface {
+	Resolve(label string, at time.Time) (Station, bool)
+}
+
+// Resolve looks the label up in the current catalogue, at is ignored.
+func (s Stations) Resolve(label string, at time.Time) (Station, bool) {
+	station, ok := s[label]
+	return station, ok
+}
+
+// StationVersion is a station as it was observed between From and To. A new
+// version starts whenever a station is renamed or moved.
+type StationVersion struct {
+	Station `bson:",inline"`
+	From    time.Time
+	To      time.Time
+}
+
+func (v StationVersion) contains(at time.Time) bool {
+	return !at.Before(v.From) && !at.After(v.To)
+}
+
+// distance returns how far at is from the validity interval of the version
+func (v StationVersion) distance(at time.Time) time.Duration {
+	switch {
+	case at.Before(v.From):
+		return v.From.Sub(at)
+	case at.After(v.To):
+		return at.Sub(v.To)
+	}
+
+	return 0
+}
+
+// StationHistory keeps every known version of every station, so that trips
+// can be matched against the catalogue as it was at the time, even after a
+// station was renamed or retired.
+type StationHistory struct {
+	cache    StationCache
+	versions map[uint64][]StationVersion // by station id, sorted by From
+	sync.RWMutex
+}
+
+// NewStationHistory loads the history stored in the cache
+func NewStationHistory(cache StationCache) *StationHistory {
+	h := &StationHistory{
+		cache:    cache,
+		versions: make(map[uint64][]StationVersion),
+	}
+
+	for id, versions := range cache.GetStationHistory() {
+		sort.Sort(station_versions(versions))
+		h.versions[id] = versions
+	}
+
+	return h
+}
+
+// Observe records that the stations were in the catalogue at the given time,
+// changes are written to the cache.
+func (h *StationHistory) Observe(stations Stations, at time.Time) {
+	changed := make(map[uint64][]StationVersion)
+
+	h.Lock()
+	for _, station := range stations {
+		if h.observe(station, at) {
+			changed[station.Id] = append([]StationVersion(nil), h.versions[station.Id]...)
+		}
+	}
+	h.Unlock()
+
+	if len(changed) > 0 {
+		h.cache.PutStationHistory(changed)
+	}
+}
+
+// observe extends the version of the station closest to at if it matches,
+// otherwise it starts a new version. Returns whether the history changed.
+func (h *StationHistory) observe(station Station, at time.Time) bool {
+	versions := h.versions[station.Id]
+
+	// First version starting after at
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].From.After(at) })
+
+	if i > 0 && same_location(versions[i-1].Station, station) {
+		if versions[i-1].contains(at) {
+			return false
+		}
+		// The status is the last one observed
+		versions[i-1].Station = station
+		versions[i-1].To = at
+		return true
+	}
+	if i < len(versions) && same_location(versions[i].Station, station) {
+		versions[i].From = at
+		return true
+	}
+
+	versions = append(versions, StationVersion{})
+	copy(versions[i+1:], versions[i:])
+	versions[i] = StationVersion{station, at, at}
+	h.versions[station.Id] = versions
+
+	return true
+}
+
+// same_location returns true if a and b are the same station with the same
+// label and coordinates, whatever their status
+func same_location(a, b Station) bool {
+	return a.Id == b.Id && a.Label == b.Label && a.Lat == b.Lat && a.Lng == b.Lng
+}
+
+// Resolve returns the station that had the label at the given time. When no
+// version was valid then, the version of that label closest in time is used.
+func (h *StationHistory) Resolve(label string, at time.Time) (Station, bool) {
+	h.RLock()
+	defer h.RUnlock()
+
+	var best StationVersion
+	found := false
+
+	for _, versions := range h.versions {
+		for _, version := range versions {
+			if version.Label != label {
+				continue
+			}
+			if !found || version.distance(at) < best.distance(at) {
+				best, found = version, true
+			}
+		}
+	}
+
+	return best.Station, found
+}
+
+// ResolveId returns the version of the station closest to the given time
+func (h *StationHistory) ResolveId(id uint64, at time.Time) (Station, bool) {
+	h.RLock()
+	defer h.RUnlock()
+
+	versions := h.versions[id]
+	if len(versions) == 0 {
+		return Station{}, false
+	}
+
+	best := versions[0]
+	for _, version := range versions[1:] {
+		if version.distance(at) < best.distance(at) {
+			best = version
+		}
+	}
+
+	return best.Station, true
+}
+
+// Labels returns every label known to the history, along with the id of the
+// station that bore it
+func (h *StationHistory) Labels() map[string]uint64 {
+	h.RLock()
+	defer h.RUnlock()
+
+	labels := make(map[string]uint64)
+	for id, versions := range h.versions {
+		for _, version := range versions {
+			labels[version.Label] = id
+		}
+	}
+
+	return labels
+}
+
+// Versions returns every known version of the station, oldest first
+func (h *StationHistory) Versions(id uint64) []StationVersion {
+	h.RLock()
+	defer h.RUnlock()
+
+	return append([]StationVersion(nil), h.versions[id]...)
+}
+
+// Aliases returns the labels the station has been known by, oldest first
+func (h *StationHistory) Aliases(id uint64) []string {
+	var aliases []string
+
+	seen := make(map[string]bool)
+	for _, version := range h.Versions(id) {
+		if !seen[version.Label] {
+			aliases = append(aliases, version.Label)
+			seen[version.Label] = true
+		}
+	}
+
+	return aliases
+}
+
+type station_versions []StationVersion
+
+func (v station_versions) Len() int           { return len(v) }
+func (v station_versions) Less(i, j int) bool { return v[i].From.Before(v[j].From) }
+func (v station_versions) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
@@ -0,0 +1,81 @@
+package bikage_test
+
+import (
+	"time"
+
+	. "github.com/Bowbaq/bikage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StationHistory", func() {
+	var history *StationHistory
+
+	jan := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	jun := time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+	dec := time.Date(2014, 12, 1, 0, 0, 0, 0, time.UTC)
+
+	old_name := Station{Id: 72, Label: "W 52 St & 11 Ave", Status: StationActive, Lat: 40.767, Lng: -73.993}
+	new_name := Station{Id: 72, Label: "W 52 St & Eleventh Ave", Status: StationActive, Lat: 40.767, Lng: -73.993}
+	retired := Station{Id: 79, Label: "Franklin St & W Broadway", Status: StationActive, Lat: 40.719, Lng: -74.006}
+
+	BeforeEach(func() {
+		history = NewStationHistory(new(NoopCache))
+		history.Observe(Stations{old_name.Label: old_name, retired.Label: retired}, jan)
+		history.Observe(Stations{old_name.Label: old_name}, jun)
+		history.Observe(Stations{new_name.Label: new_name}, dec)
+	})
+
+	It("resolves labels as of the trip date", func() {
+		station, ok := history.Resolve("W 52 St & 11 Ave", jun.AddDate(0, 0, -1))
+		Expect(ok).To(BeTrue())
+		Expect(station).To(Equal(old_name))
+	})
+
+	It("resolves old labels after a station was renamed", func() {
+		station, ok := history.Resolve("W 52 St & 11 Ave", dec.AddDate(0, 1, 0))
+		Expect(ok).To(BeTrue())
+		Expect(station).To(Equal(old_name))
+
+		station, ok = history.Resolve("W 52 St & Eleventh Ave", dec)
+		Expect(ok).To(BeTrue())
+		Expect(station).To(Equal(new_name))
+	})
+
+	It("resolves retired stations", func() {
+		station, ok := history.Resolve("Franklin St & W Broadway", dec)
+		Expect(ok).To(BeTrue())
+		Expect(station).To(Equal(retired))
+	})
+
+	It("doesn't resolve unknown labels", func() {
+		_, ok := history.Resolve("Nowhere", jun)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("keeps a version per label with its validity interval", func() {
+		Expect(history.Aliases(72)).To(Equal([]string{"W 52 St & 11 Ave", "W 52 St & Eleventh Ave"}))
+
+		versions := history.Versions(72)
+		Expect(versions).To(HaveLen(2))
+		Expect(versions[0].From).To(Equal(jan))
+		Expect(versions[0].To).To(Equal(jun))
+		Expect(versions[1].From).To(Equal(dec))
+	})
+
+	It("doesn't start a new version when only the status changes", func() {
+		closed := old_name
+		closed.Status = StationInactive
+
+		history := NewStationHistory(new(NoopCache))
+		history.Observe(Stations{old_name.Label: old_name}, jan)
+		history.Observe(Stations{closed.Label: closed}, jun)
+		history.Observe(Stations{old_name.Label: old_name}, dec)
+
+		versions := history.Versions(72)
+		Expect(versions).To(HaveLen(1))
+		Expect(versions[0].From).To(Equal(jan))
+		Expect(versions[0].To).To(Equal(dec))
+	})
+})
@@ -0,0 +1,303 @@
+package bikage
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultMatchThreshold is the similarity above which a label is matched to a
+// station automatically
+const DefaultMatchThreshold = 0.85
+
+// StationAlias maps a trip history label onto a station. Aliases found by
+// fuzzy matching are recorded with their confidence, for review.
+type StationAlias struct {
+	Label      string
+	StationId  uint64
+	Confidence float64 // 1 for aliases set by hand
+	Reviewed   bool
+}
+
+// StationMatcher resolves the labels the history doesn't know exactly: first
+// through the alias table, then by comparing normalised labels, and finally by
+// fuzzy matching. Labels that still can't be matched are counted.
+type StationMatcher struct {
+	history   *StationHistory
+	cache     AliasCache
+	threshold float64
+
+	aliases   map[string]StationAlias
+	unmatched map[string]int
+	sync.RWMutex
+}
+
+// NewStationMatcher loads the alias table from the cache. A threshold <= 0
+// selects DefaultMatchThreshold.
+func NewStationMatcher(history *StationHistory, cache AliasCache, threshold float64) *StationMatcher {
+	if threshold <= 0 {
+		threshold = DefaultMatchThreshold
+	}
+
+	m := &StationMatcher{
+		history:   history,
+		cache:     cache,
+		threshold: threshold,
+		aliases:   make(map[string]StationAlias),
+		unmatched: make(map[string]int),
+	}
+
+	for _, alias := range cache.GetAliases() {
+		m.aliases[alias.Label] = alias
+	}
+
+	return m
+}
+
+func (m *StationMatcher) Resolve(label string, at time.Time) (Station, bool) {
+	if station, ok := m.history.Resolve(label, at); ok {
+		return station, true
+	}
+
+	m.RLock()
+	alias, ok := m.aliases[label]
+	_, seen := m.unmatched[label]
+	m.RUnlock()
+
+	if !ok {
+		// Matching compares every known label, the page parsing workers
+		// shouldn't wait for each other
+		if !seen {
+			alias, ok = m.match(label)
+		}
+
+		m.Lock()
+		if !ok {
+			m.unmatched[label]++
+			m.Unlock()
+			return Station{}, false
+		}
+		// Another worker or SetAlias may have stored it meanwhile
+		existing, stored := m.aliases[label]
+		if stored {
+			alias = existing
+		} else {
+			m.aliases[label] = alias
+		}
+		m.Unlock()
+
+		if !stored {
+			m.cache.PutAlias(alias)
+		}
+	}
+
+	return m.history.ResolveId(alias.StationId, at)
+}
+
+// match finds the known label most similar to label
+func (m *StationMatcher) match(label string) (StationAlias, bool) {
+	normalized := NormalizeStationLabel(label)
+
+	best := StationAlias{Label: label}
+	for candidate, id := range m.history.Labels() {
+		confidence := label_similarity(normalized, NormalizeStationLabel(candidate))
+		if confidence > best.Confidence {
+			best.StationId, best.Confidence = id, confidence
+		}
+	}
+
+	return best, best.Confidence >= m.threshold
+}
+
+// Unmatched returns the labels that couldn't be matched since the matcher was
+// created, with the number of times they were seen
+func (m *StationMatcher) Unmatched() map[string]int {
+	m.RLock()
+	defer m.RUnlock()
+
+	unmatched := make(map[string]int)
+	for label, count := range m.unmatched {
+		unmatched[label] = count
+	}
+
+	return unmatched
+}
+
+// Aliases returns the alias table, sorted by label
+func (m *StationMatcher) Aliases() []StationAlias {
+	m.RLock()
+	defer m.RUnlock()
+
+	aliases := make([]StationAlias, 0, len(m.aliases))
+	for _, alias := range m.aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Sort(station_aliases(aliases))
+
+	return aliases
+}
+
+// SetAlias maps the label onto the station, the alias is marked as reviewed.
+// The station must be known to the history.
+func (m *StationMatcher) SetAlias(label string, station_id uint64) error {
+	if len(m.history.Versions(station_id)) == 0 {
+		return fmt.Errorf("unknown station %d", station_id)
+	}
+
+	alias := StationAlias{Label: label, StationId: station_id, Confidence: 1, Reviewed: true}
+
+	m.Lock()
+	m.aliases[label] = alias
+	delete(m.unmatched, label)
+	m.Unlock()
+
+	m.cache.PutAlias(alias)
+
+	return nil
+}
+
+// ApproveAlias marks a fuzzy match as reviewed, returns false if the label has
+// no alias
+func (m *StationMatcher) ApproveAlias(label string) bool {
+	m.Lock()
+	alias, ok := m.aliases[label]
+	if ok {
+		alias.Reviewed = true
+		m.aliases[label] = alias
+	}
+	m.Unlock()
+
+	if ok {
+		m.cache.PutAlias(alias)
+	}
+
+	return ok
+}
+
+// RemoveAlias deletes the alias of the label, a wrong fuzzy match for instance
+func (m *StationMatcher) RemoveAlias(label string) {
+	m.Lock()
+	delete(m.aliases, label)
+	m.Unlock()
+
+	m.cache.DeleteAlias(label)
+}
+
+var (
+	label_punctuation = regexp.MustCompile(`[^a-z0-9 ]+`)
+	label_ordinal     = regexp.MustCompile(`\b([0-9]+)(st|nd|rd|th)\b`)
+	label_suffix      = regexp.MustCompile(`\s*[(\[].*[)\]]\s*$`)
+
+	label_abbreviations = map[string]string{
+		"st": "street", "ave": "avenue", "av": "avenue", "pl": "place", "sq": "square",
+		"blvd": "boulevard", "pkwy": "parkway", "dr": "drive", "rd": "road", "ct": "court",
+		"hwy": "highway", "ln": "lane", "ter": "terrace", "plz": "plaza",
+		"e": "east", "w": "west", "n": "north", "s": "south",
+	}
+)
+
+// NormalizeStationLabel reduces a label to a canonical form: lower case, no
+// punctuation, "&" spelled "and", common abbreviations expanded and trailing
+// parenthesised suffixes removed.
+func NormalizeStationLabel(label string) string {
+	label = strings.ToLower(label)
+	label = label_suffix.ReplaceAllString(label, "")
+	label = strings.NewReplacer("&", " and ", "+", " and ", "@", " at ", "-", " ", "/", " ").Replace(label)
+	label = label_punctuation.ReplaceAllString(label, "")
+	label = label_ordinal.ReplaceAllString(label, "$1")
+
+	words := strings.Fields(label)
+	for i, word := range words {
+		if expanded, ok := label_abbreviations[word]; ok {
+			words[i] = expanded
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// label_similarity compares two normalised labels, ignoring word order, from 0
+// (nothing in common) to 1 (identical). Labels with different numbers never
+// match, W 52 St and W 53 St are a block apart.
+func label_similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+
+	if label_numbers(a) != label_numbers(b) {
+		return 0
+	}
+
+	a, b = sorted_words(a), sorted_words(b)
+
+	longest := utf8.RuneCountInString(a)
+	if n := utf8.RuneCountInString(b); n > longest {
+		longest = n
+	}
+	if longest == 0 {
+		return 0
+	}
+
+	return 1 - float64(levenshtein([]rune(a), []rune(b)))/float64(longest)
+}
+
+// label_numbers returns the numeric words of a normalised label, sorted
+func label_numbers(label string) string {
+	numbers := make([]string, 0)
+	for _, word := range strings.Fields(label) {
+		if strings.ContainsAny(word, "0123456789") {
+			numbers = append(numbers, word)
+		}
+	}
+	sort.Strings(numbers)
+
+	return strings.Join(numbers, " ")
+}
+
+func sorted_words(s string) string {
+	words := strings.Fields(s)
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+func levenshtein(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min_int(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func min_int(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+type station_aliases []StationAlias
+
+func (a station_aliases) Len() int           { return len(a) }
+func (a station_aliases) Less(i, j int) bool { return a[i].Label < a[j].Label }
+func (a station_aliases) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
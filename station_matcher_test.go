@@ -0,0 +1,120 @@
+package bikage_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/Bowbaq/bikage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StationMatcher", func() {
+	var (
+		dir     string
+		cache   *JsonCache
+		history *StationHistory
+		matcher *StationMatcher
+	)
+
+	now := time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	broadway := Station{Id: 72, Label: "Broadway & W 60 St", Status: StationActive, Lat: 40.769, Lng: -73.981}
+	pershing := Station{Id: 519, Label: "Pershing Square North", Status: StationActive, Lat: 40.751, Lng: -73.977}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "bikage")
+		Expect(err).NotTo(HaveOccurred())
+
+		cache = NewJsonCacheAt(filepath.Join(dir, "cache.json"))
+		history = NewStationHistory(cache)
+		history.Observe(Stations{broadway.Label: broadway, pershing.Label: pershing}, now)
+		matcher = NewStationMatcher(history, cache, DefaultMatchThreshold)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("normalizes labels", func() {
+		Expect(NormalizeStationLabel("Broadway & W. 60th St.")).To(Equal("broadway and west 60 street"))
+		Expect(NormalizeStationLabel("Pershing Square North (old)")).To(Equal("pershing square north"))
+	})
+
+	It("resolves exact labels without recording an alias", func() {
+		station, ok := matcher.Resolve("Broadway & W 60 St", now)
+		Expect(ok).To(BeTrue())
+		Expect(station).To(Equal(broadway))
+		Expect(matcher.Aliases()).To(BeEmpty())
+	})
+
+	It("matches labels that differ in spelling and records an unreviewed alias", func() {
+		station, ok := matcher.Resolve("W. 60th Street and Broadway", now)
+		Expect(ok).To(BeTrue())
+		Expect(station).To(Equal(broadway))
+
+		station, ok = matcher.Resolve("Pershing Sq North", now)
+		Expect(ok).To(BeTrue())
+		Expect(station).To(Equal(pershing))
+
+		aliases := matcher.Aliases()
+		Expect(aliases).To(HaveLen(2))
+		Expect(aliases[0].Label).To(Equal("Pershing Sq North"))
+		Expect(aliases[0].StationId).To(Equal(uint64(519)))
+		Expect(aliases[0].Reviewed).To(BeFalse())
+		Expect(aliases[1].Confidence).To(BeNumerically(">=", DefaultMatchThreshold))
+	})
+
+	It("reports labels below the threshold as unmatched", func() {
+		_, ok := matcher.Resolve("Central Park S & 6 Ave", now)
+		Expect(ok).To(BeFalse())
+		_, ok = matcher.Resolve("Central Park S & 6 Ave", now)
+		Expect(ok).To(BeFalse())
+
+		Expect(matcher.Unmatched()).To(Equal(map[string]int{"Central Park S & 6 Ave": 2}))
+		Expect(matcher.Aliases()).To(BeEmpty())
+	})
+
+	It("doesn't match labels with different street numbers", func() {
+		hells_kitchen := Station{Id: 73, Label: "W 52 St & 11 Ave", Status: StationActive, Lat: 40.767, Lng: -73.993}
+		history.Observe(Stations{broadway.Label: broadway, pershing.Label: pershing, hells_kitchen.Label: hells_kitchen}, now)
+
+		_, ok := matcher.Resolve("W 53 St & 11 Ave", now)
+		Expect(ok).To(BeFalse())
+		Expect(matcher.Aliases()).To(BeEmpty())
+
+		station, ok := matcher.Resolve("11th Ave & W. 52nd Street", now)
+		Expect(ok).To(BeTrue())
+		Expect(station).To(Equal(hells_kitchen))
+	})
+
+	It("rejects aliases to unknown stations", func() {
+		Expect(matcher.SetAlias("Grand Central", 12345)).NotTo(Succeed())
+		Expect(matcher.Aliases()).To(BeEmpty())
+	})
+
+	It("persists reviewed aliases", func() {
+		Expect(matcher.SetAlias("Grand Central", 519)).To(Succeed())
+		matcher.Resolve("Pershing Sq North", now)
+		Expect(matcher.ApproveAlias("Pershing Sq North")).To(BeTrue())
+
+		reloaded := NewStationMatcher(history, NewJsonCacheAt(filepath.Join(dir, "cache.json")), DefaultMatchThreshold)
+		Expect(reloaded.Aliases()).To(Equal([]StationAlias{
+			{Label: "Grand Central", StationId: 519, Confidence: 1, Reviewed: true},
+			matcher.Aliases()[1],
+		}))
+		Expect(reloaded.Aliases()[1].Reviewed).To(BeTrue())
+
+		station, ok := reloaded.Resolve("Grand Central", now)
+		Expect(ok).To(BeTrue())
+		Expect(station).To(Equal(pershing))
+
+		reloaded.RemoveAlias("Grand Central")
+		_, ok = reloaded.Resolve("Grand Central", now)
+		Expect(ok).To(BeFalse())
+	})
+})
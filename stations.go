@@ -1,13 +1,16 @@
 package bikage
 
 import (
-	"encoding/json"
-	"net/http"
+	"context"
 
 	"github.com/Bowbaq/distance"
 )
 
-const stations_endpoint = "http://www.citibikenyc.com/stations/json"
+// Station statuses, as reported by the retired Citi Bike stations feed
+const (
+	StationActive   = 1 // In service
+	StationInactive = 3 // Not in service
+)
 
 type Stations map[string]Station
 
@@ -23,28 +26,15 @@ func (s Station) String() string {
 	return s.Label
 }
 
-func GetStations() (Stations, error) {
-	resp, err := http.Get(stations_endpoint)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var response struct {
-		StationBeanList []Station
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, err
-	}
-
-	stations := make(Stations)
-	for _, s := range response.StationBeanList {
-		if s.Status == 1 { // Active station
-			stations[s.Label] = s
-		}
-	}
+// GetStations returns the Citi Bike stations currently installed, keyed by
+// label.
+func GetStations(ctx context.Context) (Stations, error) {
+	return NewGBFSClient(Systems[DefaultSystem].GBFS).GetStations(ctx)
+}
 
-	return stations, nil
+// HasCoord reports whether the station location is known.
+func (s Station) HasCoord() bool {
+	return s.Lat != 0 || s.Lng != 0
 }
 
 func (s Station) Coord() distance.Coord {
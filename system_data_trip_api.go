@@ -0,0 +1,364 @@
+package bikage
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type system_data_trip_api struct {
+	stations Stations
+	by_id    map[uint64]Station
+	location *time.Location
+	paths    []string
+
+	// The files are read again once they are modified
+	read      TripsResult
+	mod_times map[string]time.Time
+	sync.Mutex
+}
+
+// NewSystemDataTripAPI returns a TripAPI reading the anonymised trip histories
+// published monthly by Citi Bike (https://citibikenyc.com/system-data) and the
+// other Lyft operated systems. Files can be CSVs or zip archives of CSVs, both
+// the legacy (tripduration, starttime, ...) and the current (ride_id,
+// rideable_type, ...) schemas are supported. Times are read in location.
+//
+// Trips are those of the whole system: credentials are ignored, and since the
+// files are the source of truth, trips are kept in memory instead of being
+// cached, until the files are modified.
+func NewSystemDataTripAPI(stations Stations, location *time.Location, paths ...string) TripAPI {
+	by_id := make(map[uint64]Station)
+	for _, station := range stations {
+		by_id[station.Id] = station
+	}
+
+	return &system_data_trip_api{
+		stations: stations,
+		by_id:    by_id,
+		location: location,
+		paths:    paths,
+	}
+}
+
+func (sa *system_data_trip_api) WithCache(cache TripCache) TripAPI {
+	return sa
+}
+
+func (sa *system_data_trip_api) GetTrips(ctx context.Context, username, password string) (TripsResult, error) {
+	sa.Lock()
+	defer sa.Unlock()
+
+	mod_times := make(map[string]time.Time, len(sa.paths))
+	for _, path := range sa.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return TripsResult{}, fmt.Errorf("%s: %v", path, err)
+		}
+		mod_times[path] = info.ModTime()
+	}
+
+	if !sa.modified(mod_times) {
+		return sa.copy_read(), nil
+	}
+
+	result := TripsResult{Trips: make(Trips, 0)}
+	for _, path := range sa.paths {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		if err := sa.read_file(path, &result); err != nil {
+			return TripsResult{}, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	sort.Sort(result.Trips)
+
+	sa.read, sa.mod_times = result, mod_times
+
+	return sa.copy_read(), nil
+}
+
+// modified returns true if the files weren't read yet or changed since
+func (sa *system_data_trip_api) modified(mod_times map[string]time.Time) bool {
+	if sa.mod_times == nil {
+		return true
+	}
+
+	for path, mod_time := range mod_times {
+		if !mod_time.Equal(sa.mod_times[path]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// copy_read returns the trips read last, the callers are free to modify them
+func (sa *system_data_trip_api) copy_read() TripsResult {
+	result := sa.read
+	result.Trips = append(make(Trips, 0, len(sa.read.Trips)), sa.read.Trips...)
+	result.Skipped = append(SkippedTrips(nil), sa.read.Skipped...)
+
+	return result
+}
+
+func (sa *system_data_trip_api) GetCachedTrips(username string) Trips {
+	result, err := sa.GetTrips(context.Background(), username, "")
+	if err != nil {
+		log.Println("SystemDataTripAPI READ error ->", err)
+		return Trips{}
+	}
+
+	return result.Trips
+}
+
+func (sa *system_data_trip_api) QueryCachedTrips(username string, query TripQuery) Trips {
+	return query.Apply(sa.GetCachedTrips(username))
+}
+
+func (sa *system_data_trip_api) read_file(path string, result *TripsResult) error {
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		return sa.read_zip(path, result)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return sa.read_csv(f, path, result)
+}
+
+func (sa *system_data_trip_api) read_zip(path string, result *TripsResult) error {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	for _, file := range archive.File {
+		if !strings.EqualFold(filepath.Ext(file.Name), ".csv") || strings.HasPrefix(file.Name, "__MACOSX/") {
+			continue
+		}
+
+		r, err := file.Open()
+		if err != nil {
+			return err
+		}
+
+		err = sa.read_csv(r, path+"/"+file.Name, result)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", file.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Column names, normalized by normalize_column, of the two CSV schemas
+var (
+	legacy_schema = system_data_schema{
+		start: "starttime", end: "stoptime",
+		start_id: "startstationid", start_name: "startstationname", start_lat: "startstationlatitude", start_lng: "startstationlongitude",
+		end_id: "endstationid", end_name: "endstationname", end_lat: "endstationlatitude", end_lng: "endstationlongitude",
+	}
+	ride_schema = system_data_schema{
+		ride_id: "rideid",
+		start:   "startedat", end: "endedat",
+		start_id: "startstationid", start_name: "startstationname", start_lat: "startlat", start_lng: "startlng",
+		end_id: "endstationid", end_name: "endstationname", end_lat: "endlat", end_lng: "endlng",
+	}
+)
+
+type system_data_schema struct {
+	ride_id                                    string
+	start, end                                 string
+	start_id, start_name, start_lat, start_lng string
+	end_id, end_name, end_lat, end_lng         string
+}
+
+var system_data_time_layouts = []string{
+	"2006-01-02 15:04:05", // also parses fractional seconds
+	"1/2/2006 15:04:05",
+	"1/2/2006 15:04",
+}
+
+// read_csv appends the trips of the file to result, along with the rows that
+// can't be parsed
+func (sa *system_data_trip_api) read_csv(r io.Reader, name string, result *TripsResult) error {
+	// Rows with the wrong number of fields or stray quotes are skipped one by
+	// one below, instead of failing the whole file
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[normalize_column(name)] = i
+	}
+
+	var schema system_data_schema
+	switch {
+	case has_column(columns, ride_schema.ride_id):
+		schema = ride_schema
+	case has_column(columns, legacy_schema.start):
+		schema = legacy_schema
+	default:
+		return errors.New("unknown system data schema")
+	}
+
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if perr, ok := err.(*csv.ParseError); ok {
+			result.Skipped = append(result.Skipped, SkippedTrip{
+				File:   name,
+				Row:    perr.StartLine,
+				Reason: SkipMalformedRow,
+				Error:  perr.Error(),
+			})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		line, _ := reader.FieldPos(0)
+		skip := func(reason string, err error) {
+			result.Skipped = append(result.Skipped, SkippedTrip{
+				File:   name,
+				Row:    line,
+				Raw:    strings.Join(row, ","),
+				Reason: reason,
+				Error:  err.Error(),
+			})
+		}
+
+		start_time, err := parse_system_data_time(field(row, schema.start), sa.location)
+		if err != nil {
+			skip(SkipInvalidTime, err)
+			continue
+		}
+		end_time, err := parse_system_data_time(field(row, schema.end), sa.location)
+		if err != nil {
+			skip(SkipInvalidTime, err)
+			continue
+		}
+
+		from, ok := sa.station(field(row, schema.start_id), field(row, schema.start_name), field(row, schema.start_lat), field(row, schema.start_lng))
+		if !ok {
+			skip(SkipUnknownStation, fmt.Errorf("unknown start station %q", field(row, schema.start_name)))
+			continue
+		}
+		to, ok := sa.station(field(row, schema.end_id), field(row, schema.end_name), field(row, schema.end_lat), field(row, schema.end_lng))
+		if !ok {
+			skip(SkipUnknownStation, fmt.Errorf("unknown end station %q", field(row, schema.end_name)))
+			continue
+		}
+
+		id := field(row, schema.ride_id)
+		if id == "" {
+			id = trip_id(field(row, schema.start_name), field(row, schema.end_name), start_time, end_time)
+		}
+
+		result.Trips = append(result.Trips, Trip{
+			Id:        id,
+			Route:     Route{From: from, To: to},
+			StartedAt: start_time,
+			EndedAt:   end_time,
+		})
+	}
+
+	return nil
+}
+
+// station looks the station up by name, then by id. Stations that have been
+// retired since are rebuilt from the coordinates in the file.
+func (sa *system_data_trip_api) station(id, name, lat, lng string) (Station, bool) {
+	if name == "" {
+		return Station{}, false
+	}
+
+	if station, ok := sa.stations[name]; ok {
+		return station, true
+	}
+
+	station_id := parse_station_id(id)
+	if station, ok := sa.by_id[station_id]; ok {
+		return station, true
+	}
+
+	latitude, laterr := strconv.ParseFloat(lat, 64)
+	longitude, lngerr := strconv.ParseFloat(lng, 64)
+	if laterr != nil || lngerr != nil || (latitude == 0 && longitude == 0) {
+		return Station{}, false
+	}
+
+	return Station{Id: station_id, Label: name, Status: StationActive, Lat: latitude, Lng: longitude}, true
+}
+
+// parse_station_id returns numeric ids as is, other ids are hashed
+func parse_station_id(id string) uint64 {
+	if n, err := strconv.ParseUint(id, 10, 64); err == nil {
+		return n
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return h.Sum64()
+}
+
+func parse_system_data_time(value string, location *time.Location) (time.Time, error) {
+	var err error
+	for _, layout := range system_data_time_layouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, value, location); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, err
+}
+
+// normalize_column maps "Start Station ID", "start station id" and
+// "start_station_id" to the same name
+func normalize_column(name string) string {
+	name = strings.ToLower(strings.Trim(name, "\ufeff \""))
+	return strings.NewReplacer(" ", "", "_", "").Replace(name)
+}
+
+func has_column(columns map[string]int, name string) bool {
+	_, ok := columns[name]
+	return ok
+}
@@ -0,0 +1,174 @@
+package bikage_test
+
+import (
+	"archive/zip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/Bowbaq/bikage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const legacy_trip_data = `"tripduration","starttime","stoptime","start station id","start station name","start station latitude","start station longitude","end station id","end station name","end station latitude","end station longitude","bikeid","usertype","birth year","gender"
+"634","2014-07-01 00:00:04","2014-07-01 00:10:38","539","Metropolitan Ave & Bedford Ave","40.71534825","-73.96024116","72","W 52 St & 11 Ave","40.76727216","-73.99392888","16655","Subscriber","1979","1"
+"1547","2014-07-01 00:00:06","2014-07-01 00:25:53","2000","Retired Station","40.7","-73.9","79","Franklin St & W Broadway","40.71911552","-74.00666661","20017","Subscriber","1985","1"
+"1000","not a time","2014-07-01 00:25:53","72","W 52 St & 11 Ave","40.76727216","-73.99392888","79","Franklin St & W Broadway","40.71911552","-74.00666661","20017","Subscriber","1985","1"
+`
+
+const ride_trip_data = `ride_id,rideable_type,started_at,ended_at,start_station_name,start_station_id,end_station_name,end_station_id,start_lat,start_lng,end_lat,end_lng,member_casual
+5E2F6B1F2E9BB1D2,classic_bike,2024-02-01 08:01:02.123,2024-02-01 08:15:30.456,W 52 St & 11 Ave,6926.01,Franklin St & W Broadway,5430.08,40.767272,-73.993928,40.719116,-74.006667,member
+1A2B3C4D5E6F7A8B,electric_bike,2024-02-01 09:00:00,2024-02-01 09:20:00,,,Franklin St & W Broadway,5430.08,40.75,-73.99,40.719116,-74.006667,casual
+`
+
+var _ = Describe("SystemDataTripAPI", func() {
+	stations := Stations{
+		"W 52 St & 11 Ave":         Station{Id: 72, Label: "W 52 St & 11 Ave", Status: 1, Lat: 40.76727216, Lng: -73.99392888},
+		"Franklin St & W Broadway": Station{Id: 79, Label: "Franklin St & W Broadway", Status: 1, Lat: 40.71911552, Lng: -74.00666661},
+		"Metropolitan & Bedford":   Station{Id: 539, Label: "Metropolitan & Bedford", Status: 1, Lat: 40.71534825, Lng: -73.96024116},
+	}
+
+	new_york, _ := time.LoadLocation("America/New_York")
+
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "trip-data")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "legacy.csv"), []byte(legacy_trip_data), 0644)).To(Succeed())
+
+		f, err := os.Create(filepath.Join(dir, "rides.zip"))
+		Expect(err).NotTo(HaveOccurred())
+		archive := zip.NewWriter(f)
+		w, _ := archive.Create("202402-citibike-tripdata.csv")
+		w.Write([]byte(ride_trip_data))
+		Expect(archive.Close()).To(Succeed())
+		f.Close()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("with the legacy schema", func() {
+		var (
+			trips   Trips
+			skipped SkippedTrips
+		)
+
+		BeforeEach(func() {
+			result, err := NewSystemDataTripAPI(stations, new_york, filepath.Join(dir, "legacy.csv")).GetTrips(context.Background(), "", "")
+			Expect(err).NotTo(HaveOccurred())
+			trips = result.Trips
+			skipped = result.Skipped
+		})
+
+		It("skips the rows that can't be parsed", func() {
+			Expect(trips).To(HaveLen(2))
+		})
+
+		It("reports the rows it skipped", func() {
+			Expect(skipped).To(HaveLen(1))
+			Expect(skipped[0].File).To(Equal(filepath.Join(dir, "legacy.csv")))
+			Expect(skipped[0].Row).To(Equal(4))
+			Expect(skipped[0].Reason).To(Equal(SkipInvalidTime))
+			Expect(skipped[0].Raw).To(HavePrefix("1000,not a time,"))
+		})
+
+		It("maps stations by id when the name changed", func() {
+			Expect(trips[0].Route.From).To(Equal(stations["Metropolitan & Bedford"]))
+			Expect(trips[0].Route.To).To(Equal(stations["W 52 St & 11 Ave"]))
+			Expect(trips[0].StartedAt).To(Equal(time.Date(2014, 7, 1, 0, 0, 4, 0, new_york)))
+		})
+
+		It("keeps the trip ids when the stations resolve differently", func() {
+			renamed := Stations{"Metropolitan Av & Bedford": Station{Id: 539, Label: "Metropolitan Av & Bedford", Status: StationActive}}
+			for label, station := range stations {
+				if station.Id != 539 {
+					renamed[label] = station
+				}
+			}
+
+			result, err := NewSystemDataTripAPI(renamed, new_york, filepath.Join(dir, "legacy.csv")).GetTrips(context.Background(), "", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Trips[0].Route.From.Label).To(Equal("Metropolitan Av & Bedford"))
+			Expect(result.Trips[0].Id).To(Equal(trips[0].Id))
+		})
+
+		It("rebuilds retired stations from the file", func() {
+			Expect(trips[1].Route.From).To(Equal(Station{Id: 2000, Label: "Retired Station", Status: 1, Lat: 40.7, Lng: -73.9}))
+		})
+	})
+
+	Describe("with the ride schema", func() {
+		It("reads zip archives and keeps the ride ids", func() {
+			result, err := NewSystemDataTripAPI(stations, time.UTC, filepath.Join(dir, "rides.zip")).GetTrips(context.Background(), "", "")
+			Expect(err).NotTo(HaveOccurred())
+			trips := result.Trips
+			Expect(trips).To(HaveLen(1))
+			Expect(trips[0].Id).To(Equal("5E2F6B1F2E9BB1D2"))
+			Expect(trips[0].Route.From).To(Equal(stations["W 52 St & 11 Ave"]))
+			Expect(trips[0].Duration()).To(Equal(14*time.Minute + 28*time.Second + 333*time.Millisecond))
+
+			Expect(result.Skipped).To(HaveLen(1))
+			Expect(result.Skipped[0].File).To(Equal(filepath.Join(dir, "rides.zip") + "/202402-citibike-tripdata.csv"))
+			Expect(result.Skipped[0].Row).To(Equal(3))
+			Expect(result.Skipped[0].Reason).To(Equal(SkipUnknownStation))
+		})
+	})
+
+	It("skips malformed rows and reads the rest of the file", func() {
+		rows := strings.Split(legacy_trip_data, "\n")
+		malformed := strings.Join([]string{
+			rows[0],
+			rows[1],
+			// an extra column
+			`"634","extra","2014-07-01 00:02:00","2014-07-01 00:12:00","72","W 52 St & 11 Ave","40.76727216","-73.99392888","79","Franklin St & W Broadway","40.71911552","-74.00666661","16655","Subscriber","1979","1"`,
+			// a stray quote
+			`"634","2014-07-01 00:03:00","2014-07-01 00:13:00","72","W 52 "St" & 11 Ave","40.76727216","-73.99392888","79","Franklin St & W Broadway","40.71911552","-74.00666661","16655","Subscriber","1979","1"`,
+			rows[2],
+		}, "\n")
+		Expect(ioutil.WriteFile(filepath.Join(dir, "malformed.csv"), []byte(malformed), 0644)).To(Succeed())
+
+		result, err := NewSystemDataTripAPI(stations, new_york, filepath.Join(dir, "malformed.csv")).GetTrips(context.Background(), "", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Trips).To(HaveLen(3))
+		Expect(result.Skipped).To(HaveLen(1))
+		Expect(result.Skipped[0].Row).To(Equal(3))
+		Expect(result.Skipped[0].Reason).To(Equal(SkipInvalidTime))
+	})
+
+	It("reads the files again once they are modified", func() {
+		path := filepath.Join(dir, "legacy.csv")
+		api := NewSystemDataTripAPI(stations, new_york, path)
+
+		result, err := api.GetTrips(context.Background(), "", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Trips).To(HaveLen(2))
+
+		info, err := os.Stat(path)
+		Expect(err).NotTo(HaveOccurred())
+		rows := strings.Split(legacy_trip_data, "\n")
+		Expect(ioutil.WriteFile(path, []byte(strings.Join(rows[:2], "\n")), 0644)).To(Succeed())
+
+		// Same modification time, the trips read before are kept
+		Expect(os.Chtimes(path, info.ModTime(), info.ModTime())).To(Succeed())
+		Expect(api.GetCachedTrips("")).To(HaveLen(2))
+
+		later := info.ModTime().Add(time.Minute)
+		Expect(os.Chtimes(path, later, later)).To(Succeed())
+		Expect(api.GetCachedTrips("")).To(HaveLen(1))
+	})
+
+	It("fails on files it can't read", func() {
+		_, err := NewSystemDataTripAPI(stations, time.UTC, filepath.Join(dir, "missing.csv")).GetTrips(context.Background(), "", "")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -1,10 +1,10 @@
 package bikage
 
 import (
+	"context"
 	"crypto/sha1"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
@@ -18,28 +18,167 @@ import (
 	"github.com/PuerkitoBio/goquery"
 )
 
+// Member portal paths, relative to System.MemberSite
 const (
-	login_form     = "https://member.citibikenyc.com/profile/login"
-	login_endpoint = "https://member.citibikenyc.com/profile/login_check"
+	login_form     = "/profile/login"
+	login_endpoint = "/profile/login_check"
 )
 
 type TripAPI interface {
 	WithCache(cache TripCache) TripAPI
 
-	GetTrips(username, password string) (Trips, error)
+	// GetTrips returns the trips fetched so far along with the error when the
+	// history can only be partially fetched
+	GetTrips(ctx context.Context, username, password string) (TripsResult, error)
 	GetCachedTrips(username string) Trips
+	QueryCachedTrips(username string, query TripQuery) Trips
 }
 
+// Defaults of the pool fetching the trip history pages, shared by the users of
+// a TripAPI
+const (
+	DefaultTripWorkers = 10
+	DefaultTripRate    = 20 // pages per second
+	DefaultTripBurst   = 10
+)
+
 type trip_api struct {
 	cache    TripCache
-	stations Stations
+	system   System
+	stations StationResolver
+	full     bool
+	retry    RetryPolicy
+	http     *http.Client
+	base_url string
+
+	workers    uint
+	rate       int
+	burst      int
+	user_limit int
+	pool       *pool.Pool
+
+	users    map[string]*user_slots
+	sessions map[string]*session
+	sync.Mutex
+}
+
+// user_slots limits the pages of a user, it is dropped once no page holds or
+// waits for a slot
+type user_slots struct {
+	slots   chan bool
+	holders int
+}
+
+// session is a logged in member portal client, it is reused until the portal
+// redirects to the login form
+type session struct {
+	*citibike
+	password [sha1.Size]byte
+}
+
+// errSessionExpired is returned when the member portal asks to log in again
+var errSessionExpired = errors.New("member portal session expired")
+
+// Errors returned by TripAPI.GetTrips, possibly wrapped, test with errors.Is
+var (
+	ErrInvalidCredentials = errors.New("member portal rejected the username or password")
+	ErrCSRFNotFound       = errors.New("member portal login form has no csrf token")
+	ErrLayoutChanged      = errors.New("member portal layout changed")
+	ErrRateLimited        = errors.New("member portal is rate limiting requests")
+)
+
+// TripAPIOption configures the TripAPI returned by NewTripAPI
+type TripAPIOption func(*trip_api)
+
+// WithFullSync downloads the whole trip history on every call. By default the
+// history is walked newest first, and only until a page is already cached.
+func WithFullSync() TripAPIOption {
+	return func(ta *trip_api) {
+		ta.full = true
+	}
+}
+
+// WithRetryPolicy sets how failed requests are retried, defaults to
+// DefaultRetryPolicy
+func WithRetryPolicy(policy RetryPolicy) TripAPIOption {
+	return func(ta *trip_api) {
+		ta.retry = policy
+	}
+}
+
+// WithHTTPClient sets the client the member portal is reached with, each
+// session gets a copy with its own cookie jar
+func WithHTTPClient(client *http.Client) TripAPIOption {
+	return func(ta *trip_api) {
+		ta.http = client
+	}
+}
+
+// WithBaseURL replaces the member portal url of the system
+func WithBaseURL(base_url string) TripAPIOption {
+	return func(ta *trip_api) {
+		ta.base_url = base_url
+	}
+}
+
+// WithWorkers sets the number of pages fetched concurrently
+func WithWorkers(workers uint) TripAPIOption {
+	return func(ta *trip_api) {
+		ta.workers = workers
+	}
+}
+
+// WithRateLimit sets the number of pages fetched per second
+func WithRateLimit(rate int) TripAPIOption {
+	return func(ta *trip_api) {
+		ta.rate = rate
+	}
+}
+
+// WithBurst sets the number of pages that can be fetched at once, above the
+// rate limit
+func WithBurst(burst int) TripAPIOption {
+	return func(ta *trip_api) {
+		ta.burst = burst
+	}
+}
+
+// WithUserLimit caps the number of pages a single user can have queued or in
+// flight, so that one long history doesn't starve the other users. 0 means no
+// limit.
+func WithUserLimit(limit int) TripAPIOption {
+	return func(ta *trip_api) {
+		ta.user_limit = limit
+	}
 }
 
-func NewTripAPI(stations Stations) TripAPI {
-	return &trip_api{
+// NewTripAPI returns a TripAPI scraping the trip history from the member portal
+// of the system. Trip stations are looked up by label, either in the current
+// catalogue (Stations) or as of the trip date (StationHistory).
+func NewTripAPI(system System, stations StationResolver, options ...TripAPIOption) TripAPI {
+	ta := &trip_api{
 		cache:    new(NoopCache),
+		system:   system,
 		stations: stations,
+		retry:    DefaultRetryPolicy,
+		http:     &http.Client{Timeout: http_timeout},
+		base_url: system.MemberSite,
+
+		workers: DefaultTripWorkers,
+		rate:    DefaultTripRate,
+		burst:   DefaultTripBurst,
+
+		users:    make(map[string]*user_slots),
+		sessions: make(map[string]*session),
+	}
+
+	for _, option := range options {
+		option(ta)
 	}
+
+	ta.pool = pool.NewRateLimitedPool(ta.workers, ta.rate, ta.burst, fetch_trips)
+
+	return ta
 }
 
 func (ta *trip_api) WithCache(cache TripCache) TripAPI {
@@ -47,39 +186,165 @@ func (ta *trip_api) WithCache(cache TripCache) TripAPI {
 	return ta
 }
 
-func (ta *trip_api) GetTrips(username, password string) (Trips, error) {
-	citibike, err := new_citibike(username, password, &ta.stations)
+func (ta *trip_api) GetTrips(ctx context.Context, username, password string) (TripsResult, error) {
+	citibike, err := ta.session(ctx, username, password, false)
+	if err != nil {
+		return TripsResult{}, err
+	}
+
+	result, err := citibike.get_all_trips(ctx, username, ta.cache, ta.full)
+	if err == errSessionExpired {
+		citibike, err = ta.session(ctx, username, password, true)
+		if err != nil {
+			return TripsResult{}, err
+		}
+
+		result, err = citibike.get_all_trips(ctx, username, ta.cache, ta.full)
+	}
+
+	return result, err
+}
+
+// session returns the session of the user, logging in if there is none, the
+// password differs or renew is set
+func (ta *trip_api) session(ctx context.Context, username, password string, renew bool) (*citibike, error) {
+	hash := sha1.Sum([]byte(password))
+
+	ta.Lock()
+	s, ok := ta.sessions[username]
+	ta.Unlock()
+
+	if ok && !renew && s.password == hash {
+		return s.citibike, nil
+	}
+
+	citibike, err := new_citibike(ctx, username, password, ta)
 	if err != nil {
 		return nil, err
 	}
+	citibike.pool = ta.pool
+
+	ta.Lock()
+	ta.sessions[username] = &session{citibike, hash}
+	ta.Unlock()
 
-	return citibike.get_all_trips(username, ta.cache)
+	return citibike, nil
 }
 
 func (ta *trip_api) GetCachedTrips(username string) Trips {
 	return ta.cache.GetTrips(username)
 }
 
+func (ta *trip_api) QueryCachedTrips(username string, query TripQuery) Trips {
+	return ta.cache.QueryTrips(username, query)
+}
+
+// acquire waits for a free slot of the user, unless users aren't limited
+func (ta *trip_api) acquire(ctx context.Context, username string) error {
+	if ta.user_limit <= 0 {
+		return nil
+	}
+
+	ta.Lock()
+	user, ok := ta.users[username]
+	if !ok {
+		user = &user_slots{slots: make(chan bool, ta.user_limit)}
+		ta.users[username] = user
+	}
+	user.holders++
+	ta.Unlock()
+
+	select {
+	case user.slots <- true:
+		return nil
+	case <-ctx.Done():
+		ta.Lock()
+		ta.leave(username, user)
+		ta.Unlock()
+		return ctx.Err()
+	}
+}
+
+// release frees a slot acquired by the user
+func (ta *trip_api) release(username string) {
+	if ta.user_limit <= 0 {
+		return
+	}
+
+	ta.Lock()
+	defer ta.Unlock()
+
+	user := ta.users[username]
+	<-user.slots
+	ta.leave(username, user)
+}
+
+// leave drops the slots of the user with their last holder, ta must be locked
+func (ta *trip_api) leave(username string, user *user_slots) {
+	user.holders--
+	if user.holders == 0 {
+		delete(ta.users, username)
+	}
+}
+
 type citibike struct {
 	http       *http.Client
-	stations   *Stations
+	retry      RetryPolicy
+	stations   StationResolver
 	trips_path string
+
+	pool *pool.Pool
+
+	// acquire and release hold a slot of the user while a page is fetched
+	acquire func(ctx context.Context) error
+	release func()
+
+	// renew logs in again once the session expired
+	renew func(ctx context.Context) (*citibike, error)
+
+	base_url    string
+	time_layout string
+	location    *time.Location
 }
 
-func new_citibike(username, password string, stations *Stations) (*citibike, error) {
+func new_citibike(ctx context.Context, username, password string, ta *trip_api) (*citibike, error) {
 	jar, err := cookiejar.New(nil)
 	if err != nil {
 		return nil, err
 	}
 
+	client := *ta.http
+	client.Jar = jar
+
 	cb := citibike{
-		http:     &http.Client{Jar: jar},
-		stations: stations,
+		http:     &client,
+		retry:    ta.retry,
+		stations: ta.stations,
+
+		base_url:    ta.base_url,
+		time_layout: ta.system.time_layout(),
+		location:    ta.system.Location(),
+	}
+	cb.acquire = func(ctx context.Context) error {
+		return ta.acquire(ctx, username)
+	}
+	cb.release = func() {
+		ta.release(username)
+	}
+	cb.renew = func(ctx context.Context) (*citibike, error) {
+		return ta.session(ctx, username, password, true)
 	}
 
-	csrf, err := cb.get_csrf()
+	// The CSRF token is spent by the login attempt, a retry starts over with a
+	// new one
+	err = cb.retry.Do(ctx, func() error {
+		csrf, err := cb.get_csrf(ctx)
+		if err != nil {
+			return err
+		}
 
-	err = cb.login(username, password, csrf)
+		return cb.login(ctx, username, password, csrf)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -87,8 +352,8 @@ func new_citibike(username, password string, stations *Stations) (*citibike, err
 	return &cb, nil
 }
 
-func (cb *citibike) get_csrf() (string, error) {
-	resp, err := cb.http.Get(login_form)
+func (cb *citibike) get_csrf(ctx context.Context) (string, error) {
+	resp, err := send_once.get(ctx, cb.http, cb.base_url+login_form)
 	if err != nil {
 		return "", err
 	}
@@ -99,16 +364,16 @@ func (cb *citibike) get_csrf() (string, error) {
 		return "", err
 	}
 
-	csrf, ok := doc.Find(`#loginPopupId input[name="_login_csrf_security_token"]`).Attr("value")
+	csrf, _, ok := find_attr(doc, func(p SelectorProfile) string { return p.CSRF }, "value")
 	if !ok {
-		return "", errors.New("couldn't find csrf token")
+		return "", ErrCSRFNotFound
 	}
 
 	return csrf, nil
 }
 
-func (cb *citibike) login(username, password, csrf string) error {
-	resp, err := cb.http.PostForm(login_endpoint, url.Values{
+func (cb *citibike) login(ctx context.Context, username, password, csrf string) error {
+	resp, err := send_once.post_form(ctx, cb.http, cb.base_url+login_endpoint, url.Values{
 		"_username":                  {username},
 		"_password":                  {password},
 		"_login_csrf_security_token": {csrf},
@@ -123,9 +388,13 @@ func (cb *citibike) login(username, password, csrf string) error {
 		return err
 	}
 
-	trips_path, ok := doc.Find(".ed-profile-menu__link_trips a").Attr("href")
+	trips_path, _, ok := find_attr(doc, func(p SelectorProfile) string { return p.TripsLink }, "href")
 	if !ok {
-		return errors.New("couldn't find trips page link")
+		// The login form is displayed again when the credentials are wrong
+		if doc.Find(`input[name="_password"]`).Length() > 0 {
+			return ErrInvalidCredentials
+		}
+		return fmt.Errorf("%w: couldn't find the trips page link", ErrLayoutChanged)
 	}
 	cb.trips_path = trips_path
 
@@ -133,75 +402,255 @@ func (cb *citibike) login(username, password, csrf string) error {
 }
 
 type fetchTrips struct {
-	wg   *sync.WaitGroup
-	page int
-	cb   *citibike
+	ctx     context.Context
+	wg      *sync.WaitGroup
+	page    int
+	profile *SelectorProfile
+	cb      *citibike
 }
 
-var tripPool = pool.NewRateLimitedPool(10, 20, 10, func(id uint, payload interface{}) interface{} {
+func fetch_trips(id uint, payload interface{}) interface{} {
 	job := payload.(fetchTrips)
 	defer job.wg.Done()
+	defer job.cb.release()
 
-	doc, err := job.cb.get_trips_document(job.cb.trips_path + "?pageNumber=" + strconv.Itoa(job.page))
+	doc, err := job.cb.get_trips_document(job.ctx, job.cb.trips_path+"?pageNumber="+strconv.Itoa(job.page))
 	if err != nil {
 		return err
 	}
 
-	return job.cb.parse_trips(doc)
-})
+	return job.cb.parse_trips(doc, job.profile, job.page)
+}
 
-func (cb *citibike) get_all_trips(username string, cache TripCache) (Trips, error) {
-	doc, err := cb.get_trips_document(cb.trips_path)
-	if err != nil {
+// submit queues the page on the pool, once the user has a free slot
+func (cb *citibike) submit(ctx context.Context, wg *sync.WaitGroup, profile *SelectorProfile, page int) (pool.Job, error) {
+	if err := cb.acquire(ctx); err != nil {
 		return nil, err
 	}
 
-	next_page, last_page := parse_navigation(doc, cb.trips_path)
+	wg.Add(1)
+	job := pool.NewJob(fetchTrips{ctx, wg, page, profile, cb})
+	cb.pool.Submit(job)
 
-	var wg sync.WaitGroup
-	wg.Add(last_page - next_page + 1)
+	return job, nil
+}
+
+// get_all_trips returns the trip history merged with the cached trips. Unless
+// full is set, pages are fetched newest first until one is entirely cached.
+// Pages that can't be fetched are listed in the result, the history is only
+// abandoned if ctx is done. The trips fetched are cached either way, and the
+// next sync of an incomplete history reads every page again.
+func (cb *citibike) get_all_trips(ctx context.Context, username string, cache TripCache, full bool) (TripsResult, error) {
+	doc, err := cb.get_trips_document(ctx, cb.trips_path)
+	if err != nil {
+		return TripsResult{}, err
+	}
 
-	var jobs []pool.Job
+	profile, ok := match_trips_page(doc)
+	if !ok {
+		return TripsResult{}, fmt.Errorf("%w: couldn't find the trips table", ErrLayoutChanged)
+	}
+
+	next_page, last_page := parse_navigation(doc, profile, cb.trips_path)
+
+	result := cb.parse_trips(doc, profile, 1)
+	result.Profile = profile.Name
+	if full || cache.GetIncomplete(username) {
+		err = cb.get_pages(ctx, &result, profile, next_page, last_page)
+	} else if !is_cached(result.Trips, username, cache) {
+		err = cb.get_new_pages(ctx, &result, profile, username, cache, next_page, last_page)
+	}
+
+	for _, trip := range result.Trips {
+		if full || !cached_as_is(trip, username, cache) {
+			cache.PutTrip(username, trip)
+		}
+	}
+	cache.PutIncomplete(username, err != nil || len(result.Failed) > 0)
+
+	result.Trips = merge_trips(cache.GetTrips(username), result.Trips)
+
+	return result, err
+}
+
+// get_pages fetches the pages concurrently. The pages the session expired on
+// are fetched again after logging in.
+func (cb *citibike) get_pages(ctx context.Context, result *TripsResult, profile *SelectorProfile, next_page, last_page int) error {
+	var pages []int
 	for p := next_page; p <= last_page; p++ {
-		job := pool.NewJob(fetchTrips{&wg, p, cb})
-		tripPool.Submit(job)
-		jobs = append(jobs, job)
+		pages = append(pages, p)
+	}
+
+	fetched := cb.fetch_pages(ctx, profile, pages)
+
+	var expired []int
+	for _, p := range pages {
+		if fetched[p] == errSessionExpired {
+			expired = append(expired, p)
+		}
+	}
+	if len(expired) > 0 {
+		for p, page := range cb.fetch_renewed(ctx, profile, expired) {
+			fetched[p] = page
+		}
+	}
+
+	for _, p := range pages {
+		page, ok := fetched[p]
+		if !ok {
+			continue
+		}
+		if err := add_page(result, page); err != nil {
+			result.Failed = append(result.Failed, FailedPage{p, err.Error()})
+		}
+	}
+
+	return ctx.Err()
+}
+
+// fetch_pages returns the trips or the error of each page, pages that
+// couldn't be submitted before ctx is done are missing
+func (cb *citibike) fetch_pages(ctx context.Context, profile *SelectorProfile, pages []int) map[int]interface{} {
+	var wg sync.WaitGroup
+
+	jobs := make(map[int]pool.Job, len(pages))
+	for _, p := range pages {
+		job, err := cb.submit(ctx, &wg, profile, p)
+		if err != nil {
+			break
+		}
+		jobs[p] = job
 	}
 	wg.Wait()
 
-	trips := cb.parse_trips(doc)
-	for _, job := range jobs {
-		result := job.Result()
-		switch result.(type) {
-		case Trips:
-			trips = append(trips, result.(Trips)...)
-		case error:
-			return trips, result.(error)
-		default:
-			return trips, errors.New("Unexpected return type from the pool")
+	fetched := make(map[int]interface{}, len(jobs))
+	for p, job := range jobs {
+		fetched[p] = job.Result()
+	}
+
+	return fetched
+}
+
+// fetch_renewed logs in again and fetches the pages with the new session
+func (cb *citibike) fetch_renewed(ctx context.Context, profile *SelectorProfile, pages []int) map[int]interface{} {
+	renewed, err := cb.renew(ctx)
+	if err != nil {
+		fetched := make(map[int]interface{}, len(pages))
+		for _, p := range pages {
+			fetched[p] = err
 		}
+		return fetched
 	}
 
-	sort.Sort(trips)
+	return renewed.fetch_pages(ctx, profile, pages)
+}
+
+func add_page(result *TripsResult, page interface{}) error {
+	switch page.(type) {
+	case TripsResult:
+		result.Trips = append(result.Trips, page.(TripsResult).Trips...)
+		result.Skipped = append(result.Skipped, page.(TripsResult).Skipped...)
+	case error:
+		return page.(error)
+	default:
+		return errors.New("Unexpected return type from the pool")
+	}
+
+	return nil
+}
+
+// get_new_pages fetches the pages one by one, stopping at the first page whose
+// trips are all cached. The session is renewed once if it expires.
+func (cb *citibike) get_new_pages(ctx context.Context, result *TripsResult, profile *SelectorProfile, username string, cache TripCache, next_page, last_page int) error {
+	renewed := false
+	for p := next_page; p <= last_page; p++ {
+		page, ok := cb.fetch_pages(ctx, profile, []int{p})[p]
+		if page == errSessionExpired && !renewed {
+			renewed = true
+			if session, err := cb.renew(ctx); err != nil {
+				page = err
+			} else {
+				cb = session
+				page, ok = cb.fetch_pages(ctx, profile, []int{p})[p]
+			}
+		}
+		if !ok {
+			return ctx.Err()
+		}
+
+		if err := add_page(result, page); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			result.Failed = append(result.Failed, FailedPage{p, err.Error()})
+			continue
+		}
+
+		if is_cached(page.(TripsResult).Trips, username, cache) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// is_cached returns true if there are trips and all of them are cached as is
+func is_cached(trips Trips, username string, cache TripCache) bool {
 	for _, trip := range trips {
-		cache.PutTrip(username, trip)
+		if !cached_as_is(trip, username, cache) {
+			return false
+		}
+	}
+
+	return len(trips) > 0
+}
+
+// cached_as_is returns true if the trip is cached with the same times. Older
+// versions read the times as UTC, such trips are cached again.
+func cached_as_is(trip Trip, username string, cache TripCache) bool {
+	cached, found := cache.GetTrip(username, trip.Id)
+
+	return found && cached.StartedAt.Equal(trip.StartedAt) && cached.EndedAt.Equal(trip.EndedAt)
+}
+
+// merge_trips returns the union of both lists, sorted, fetched trips replace
+// the cached trips with the same id
+func merge_trips(cached, fetched Trips) Trips {
+	by_id := make(map[string]Trip, len(cached)+len(fetched))
+	for _, trip := range cached {
+		by_id[trip.Id] = trip
+	}
+	for _, trip := range fetched {
+		by_id[trip.Id] = trip
+	}
+
+	trips := make(Trips, 0, len(by_id))
+	for _, trip := range by_id {
+		trips = append(trips, trip)
 	}
+	sort.Sort(trips)
 
-	return trips, nil
+	return trips
 }
 
-func (cb *citibike) get_trips_document(path string) (*goquery.Document, error) {
-	resp, err := cb.http.Get("https://member.citibikenyc.com" + path)
+func (cb *citibike) get_trips_document(ctx context.Context, path string) (*goquery.Document, error) {
+	resp, err := cb.retry.get(ctx, cb.http, cb.base_url+path)
 	if err != nil {
 		return nil, err
 	}
 
+	// Expired sessions are redirected to the login form
+	if strings.HasSuffix(resp.Request.URL.Path, login_form) {
+		resp.Body.Close()
+		return nil, errSessionExpired
+	}
+
 	return goquery.NewDocumentFromResponse(resp)
 }
 
-func parse_navigation(doc *goquery.Document, trips_path string) (int, int) {
-	next, nok := doc.Find(".ed-paginated-navigation__pages-group__link_next").Attr("href")
-	last, lok := doc.Find(".ed-paginated-navigation__pages-group__link_last").Attr("href")
+func parse_navigation(doc *goquery.Document, profile *SelectorProfile, trips_path string) (int, int) {
+	next, nok := doc.Find(profile.NextPage).Attr("href")
+	last, lok := doc.Find(profile.LastPage).Attr("href")
 	if !nok || !lok {
 		return 1, 1
 	}
@@ -218,34 +667,47 @@ func parse_navigation(doc *goquery.Document, trips_path string) (int, int) {
 	return next_page, last_page
 }
 
-func (cb *citibike) parse_trips(doc *goquery.Document) Trips {
-	var trips Trips
+func (cb *citibike) parse_trips(doc *goquery.Document, profile *SelectorProfile, page int) TripsResult {
+	var result TripsResult
+
+	doc.Find(profile.TripRow).Each(func(i int, tr *goquery.Selection) {
+		skip := func(reason string, err error) {
+			result.Skipped = append(result.Skipped, SkippedTrip{
+				Page:   page,
+				Raw:    strings.Join(strings.Fields(tr.Text()), " "),
+				Reason: reason,
+				Error:  err.Error(),
+			})
+		}
 
-	doc.Find(".ed-table__items .ed-table__item_trip").Each(func(i int, tr *goquery.Selection) {
-		start_station, err := cb.parse_station(tr, ".ed-table__item__info__sub-info_trip-start-station")
+		start_time, err := cb.parse_time(tr, profile.StartDate)
 		if err != nil {
-			log.Println("COULDN'T PARSE START STATION", err)
+			skip(SkipInvalidTime, err)
 			return
 		}
 
-		end_station, err := cb.parse_station(tr, ".ed-table__item__info__sub-info_trip-end-station")
+		end_time, err := cb.parse_time(tr, profile.EndDate)
 		if err != nil {
-			log.Println("COULDN'T PARSE END STATION", err)
+			skip(SkipInvalidTime, err)
 			return
 		}
 
-		start_time, err := parse_time(tr, ".ed-table__item__info__sub-info_trip-start-date")
+		start_label := tr.Find(profile.StartStation).Text()
+		start_station, err := cb.parse_station(start_label, start_time)
 		if err != nil {
+			skip(SkipUnknownStation, err)
 			return
 		}
 
-		end_time, err := parse_time(tr, ".ed-table__item__info__sub-info_trip-end-date")
+		end_label := tr.Find(profile.EndStation).Text()
+		end_station, err := cb.parse_station(end_label, end_time)
 		if err != nil {
+			skip(SkipUnknownStation, err)
 			return
 		}
 
 		trip := Trip{
-			Id: fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("%s-%s-%s-%s", start_station.Label, end_station.Label, start_time, end_time)))),
+			Id: trip_id(start_label, end_label, start_time, end_time),
 			Route: Route{
 				From: start_station,
 				To:   end_station,
@@ -254,21 +716,95 @@ func (cb *citibike) parse_trips(doc *goquery.Document) Trips {
 			EndedAt:   end_time,
 		}
 
-		trips = append(trips, trip)
+		result.Trips = append(result.Trips, trip)
 	})
 
-	return trips
+	return result
 }
 
-func (cb *citibike) parse_station(node *goquery.Selection, name_div string) (Station, error) {
-	station_label := node.Find(name_div).Text()
-	if station, ok := (*cb.stations)[station_label]; ok {
+func (cb *citibike) parse_station(station_label string, at time.Time) (Station, error) {
+	if station, ok := cb.stations.Resolve(station_label, at); ok {
 		return station, nil
 	}
 
 	return Station{}, fmt.Errorf("Unknown station: %s", station_label)
 }
 
-func parse_time(node *goquery.Selection, time_div string) (time.Time, error) {
-	return time.Parse("01/02/2006 3:04:05 PM", node.Find(time_div).Text())
+// trip_id identifies trips that don't come with an id of their own. from and
+// to are the station labels as read, the id doesn't change with the station
+// they resolve to.
+func trip_id(from, to string, start, end time.Time) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("%s-%s-%s-%s", from, to, wall_clock(start), wall_clock(end)))))
+}
+
+// wall_clock returns the local time of t as if it were UTC. Trip times used to
+// be parsed as UTC, hashing them this way keeps the ids of the cached trips
+// whatever the zone of the system.
+func wall_clock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+func (cb *citibike) parse_time(node *goquery.Selection, time_div string) (time.Time, error) {
+	return time.ParseInLocation(cb.time_layout, node.Find(time_div).Text(), cb.location)
+}
+
+// Diagnosis reports how much of the member portal can still be read
+type Diagnosis struct {
+	// Login is the login error, nil if the login succeeded
+	Login error
+	// Page is the error reading the first trip history page
+	Page error
+
+	// Profile is the name of the SelectorProfile matching the trips page
+	Profile string
+	// Navigation is set when the links to the other pages were found, Pages is
+	// the number of pages they point to
+	Navigation bool
+	Pages      int
+
+	// Rows is the number of trip rows on the first page, Trips the number of
+	// rows that could be parsed
+	Rows    int
+	Trips   int
+	Skipped SkippedTrips
+}
+
+// Diagnoser is implemented by the TripAPIs reading a member portal
+type Diagnoser interface {
+	Diagnose(ctx context.Context, username, password string) Diagnosis
+}
+
+// Diagnose logs in and reads the first page of the trip history, the session
+// isn't kept and nothing is cached
+func (ta *trip_api) Diagnose(ctx context.Context, username, password string) Diagnosis {
+	var d Diagnosis
+
+	cb, err := new_citibike(ctx, username, password, ta)
+	if err != nil {
+		d.Login = err
+		return d
+	}
+
+	doc, err := cb.get_trips_document(ctx, cb.trips_path)
+	if err != nil {
+		d.Page = err
+		return d
+	}
+
+	profile, ok := match_trips_page(doc)
+	if !ok {
+		d.Page = fmt.Errorf("%w: couldn't find the trips table", ErrLayoutChanged)
+		return d
+	}
+	d.Profile = profile.Name
+
+	_, d.Pages = parse_navigation(doc, profile, cb.trips_path)
+	d.Navigation = doc.Find(profile.NextPage).Length() > 0 && doc.Find(profile.LastPage).Length() > 0
+
+	page := cb.parse_trips(doc, profile, 1)
+	d.Rows = doc.Find(profile.TripRow).Length()
+	d.Trips = len(page.Trips)
+	d.Skipped = page.Skipped
+
+	return d
 }
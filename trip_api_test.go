@@ -0,0 +1,461 @@
+package bikage_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	. "github.com/Bowbaq/bikage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fake_citibike serves the member portal pages in testdata/citibike
+type fake_citibike struct {
+	*httptest.Server
+
+	CSRF      string
+	TripsPath string
+
+	templates   *template.Template
+	fail_page   int
+	expire_page int    // expires the session when the page is first requested
+	status      int    // replaces the status of the trips pages
+	page        string // replaces the trips pages
+	hits        map[int]int
+	logins      int
+	busy_logins int // fails the logins with a 503, spending their csrf token
+	session     string
+	sync.Mutex
+}
+
+func new_fake_citibike() *fake_citibike {
+	fc := &fake_citibike{
+		CSRF:      "2c4f8d1e",
+		TripsPath: "/profile/trips/QX7T3K",
+		templates: template.Must(template.ParseGlob(filepath.Join("testdata", "citibike", "*.html"))),
+		hits:      make(map[int]int),
+		session:   "1",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/profile/login", func(w http.ResponseWriter, r *http.Request) {
+		fc.render(w, "login.html")
+	})
+	mux.HandleFunc("/profile/login_check", func(w http.ResponseWriter, r *http.Request) {
+		fc.Lock()
+		busy := fc.busy_logins > 0
+		if busy {
+			fc.busy_logins--
+			fc.CSRF += "0"
+		}
+		fc.Unlock()
+
+		if busy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.PostFormValue("_login_csrf_security_token") != fc.CSRF ||
+			r.PostFormValue("_username") != "user" || r.PostFormValue("_password") != "pass" {
+			fc.render(w, "login.html")
+			return
+		}
+
+		fc.Lock()
+		fc.logins++
+		session := fc.session
+		fc.Unlock()
+
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: session, Path: "/"})
+		fc.render(w, "profile.html")
+	})
+	mux.HandleFunc(fc.TripsPath, func(w http.ResponseWriter, r *http.Request) {
+		page := 1
+		if n, err := strconv.Atoi(r.URL.Query().Get("pageNumber")); err == nil {
+			page = n
+		}
+
+		fc.Lock()
+		if page == fc.expire_page {
+			fc.expire_page = 0
+			fc.session += "1"
+		}
+		cookie, err := r.Cookie("session")
+		expired := err != nil || cookie.Value != fc.session
+		if !expired {
+			fc.hits[page]++
+		}
+		fail := page == fc.fail_page
+		fc.Unlock()
+
+		if expired {
+			http.Redirect(w, r, "/profile/login", http.StatusFound)
+			return
+		}
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if fc.status != 0 {
+			w.WriteHeader(fc.status)
+			return
+		}
+		if fc.page != "" {
+			fc.render(w, fc.page)
+			return
+		}
+		fc.render(w, "trips_"+strconv.Itoa(page)+".html")
+	})
+	fc.Server = httptest.NewServer(mux)
+
+	return fc
+}
+
+func (fc *fake_citibike) render(w http.ResponseWriter, name string) {
+	if err := fc.templates.ExecuteTemplate(w, name, fc); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// expire invalidates the sessions opened so far
+func (fc *fake_citibike) expire() {
+	fc.Lock()
+	fc.session += "1"
+	fc.Unlock()
+}
+
+func (fc *fake_citibike) Logins() int {
+	fc.Lock()
+	defer fc.Unlock()
+	return fc.logins
+}
+
+func (fc *fake_citibike) Hits() map[int]int {
+	fc.Lock()
+	defer fc.Unlock()
+
+	hits := make(map[int]int)
+	for page, n := range fc.hits {
+		hits[page] = n
+	}
+	return hits
+}
+
+var _ = Describe("TripAPI", func() {
+	var (
+		server *fake_citibike
+		api    TripAPI
+	)
+
+	new_york, _ := time.LoadLocation("America/New_York")
+	ctx := context.Background()
+
+	hells_kitchen := Station{Id: 72, Label: "W 52 St & 11 Ave", Status: StationActive, Lat: 40.767, Lng: -73.993}
+	tribeca := Station{Id: 79, Label: "Franklin St & W Broadway", Status: StationActive, Lat: 40.719, Lng: -74.006}
+	stations := Stations{hells_kitchen.Label: hells_kitchen, tribeca.Label: tribeca}
+
+	no_retry := RetryPolicy{Attempts: 1}
+
+	new_api := func(options ...TripAPIOption) TripAPI {
+		options = append([]TripAPIOption{
+			WithHTTPClient(server.Client()),
+			WithBaseURL(server.URL),
+			WithRetryPolicy(no_retry),
+		}, options...)
+
+		return NewTripAPI(Systems[DefaultSystem], stations, options...)
+	}
+
+	BeforeEach(func() {
+		server = new_fake_citibike()
+		api = new_api()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("GetTrips()", func() {
+		It("follows the navigation to every page of the history", func() {
+			result, err := api.GetTrips(ctx, "user", "pass")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.Hits()).To(Equal(map[int]int{1: 1, 2: 1, 3: 1}))
+			Expect(result.Trips).To(HaveLen(4))
+			Expect(result.Failed).To(BeEmpty())
+		})
+
+		It("records the selector profile the history was read with", func() {
+			result, _ := api.GetTrips(ctx, "user", "pass")
+			Expect(result.Profile).To(Equal(SelectorProfiles[0].Name))
+		})
+
+		Context("with a profile for another layout first", func() {
+			profiles := SelectorProfiles
+
+			BeforeEach(func() {
+				redesign := SelectorProfile{
+					Name:       "redesign",
+					CSRF:       `#login input[name="csrf"]`,
+					TripsLink:  ".menu__trips a",
+					TripsTable: ".trips",
+					TripRow:    ".trips .trip",
+				}
+				SelectorProfiles = append([]SelectorProfile{redesign}, profiles...)
+			})
+
+			AfterEach(func() {
+				SelectorProfiles = profiles
+			})
+
+			It("falls back to the next profile and records it", func() {
+				result, err := api.GetTrips(ctx, "user", "pass")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Trips).To(HaveLen(4))
+				Expect(result.Profile).To(Equal("ed-2019"))
+
+				Expect(api.(Diagnoser).Diagnose(ctx, "user", "pass").Profile).To(Equal("ed-2019"))
+			})
+		})
+
+		It("parses trip times in the zone of the system and resolves the stations", func() {
+			result, _ := api.GetTrips(ctx, "user", "pass")
+
+			trip := result.Trips[len(result.Trips)-1]
+			Expect(trip.StartedAt).To(Equal(time.Date(2014, 7, 2, 8, 15, 0, 0, new_york)))
+			Expect(trip.EndedAt).To(Equal(time.Date(2014, 7, 2, 8, 29, 30, 0, new_york)))
+			Expect(trip.Route).To(Equal(Route{From: hells_kitchen, To: tribeca}))
+			Expect(trip.Id).NotTo(BeEmpty())
+		})
+
+		It("keeps the trip ids of the times parsed as UTC", func() {
+			result, _ := api.GetTrips(ctx, "user", "pass")
+
+			trip := result.Trips[len(result.Trips)-1]
+			Expect(trip.Id).To(Equal("1a2f0ae850f758e75afea022f40ff0b82fe87266"))
+		})
+
+		It("reports the rows it couldn't parse", func() {
+			result, _ := api.GetTrips(ctx, "user", "pass")
+
+			Expect(result.Skipped).To(HaveLen(2))
+			Expect(result.Skipped[0].Page).To(Equal(2))
+			Expect(result.Skipped[0].Reason).To(Equal(SkipInvalidTime))
+			Expect(result.Skipped[1].Page).To(Equal(3))
+			Expect(result.Skipped[1].Reason).To(Equal(SkipUnknownStation))
+			Expect(result.Skipped[1].Raw).To(ContainSubstring("Nowhere"))
+		})
+
+		It("fails when the credentials are rejected", func() {
+			_, err := api.GetTrips(ctx, "user", "wrong")
+			Expect(err).To(Equal(ErrInvalidCredentials))
+			Expect(server.Hits()).To(BeEmpty())
+		})
+
+		It("logs in again with a new csrf token after a transient failure", func() {
+			server.busy_logins = 1
+			api = new_api(WithRetryPolicy(RetryPolicy{Attempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}))
+
+			result, err := api.GetTrips(ctx, "user", "pass")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Trips).To(HaveLen(4))
+			Expect(server.Logins()).To(Equal(1))
+		})
+
+		It("fails when the login form has no csrf token", func() {
+			server.CSRF = ""
+			_, err := api.GetTrips(ctx, "user", "pass")
+			Expect(err).To(Equal(ErrCSRFNotFound))
+		})
+
+		It("fails when the trips page can't be read", func() {
+			server.page = "profile.html"
+			_, err := api.GetTrips(ctx, "user", "pass")
+			Expect(errors.Is(err, ErrLayoutChanged)).To(BeTrue())
+		})
+
+		It("fails when rate limited", func() {
+			server.status = http.StatusTooManyRequests
+			_, err := api.GetTrips(ctx, "user", "pass")
+			Expect(errors.Is(err, ErrRateLimited)).To(BeTrue())
+		})
+
+		It("returns the history without the pages it couldn't fetch", func() {
+			server.fail_page = 2
+
+			result, err := api.GetTrips(ctx, "user", "pass")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Trips).To(HaveLen(3))
+			Expect(result.Failed).To(HaveLen(1))
+			Expect(result.Failed[0].Page).To(Equal(2))
+		})
+
+		It("syncs again once the user slots are released", func() {
+			api = new_api(WithUserLimit(1), WithFullSync())
+
+			for i := 0; i < 2; i++ {
+				result, err := api.GetTrips(ctx, "user", "pass")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Trips).To(HaveLen(4))
+			}
+			Expect(server.Hits()).To(Equal(map[int]int{1: 2, 2: 2, 3: 2}))
+		})
+
+		It("reuses the session of the user", func() {
+			api.GetTrips(ctx, "user", "pass")
+			api.GetTrips(ctx, "user", "pass")
+			Expect(server.Logins()).To(Equal(1))
+		})
+
+		It("doesn't reuse the session with another password", func() {
+			api.GetTrips(ctx, "user", "pass")
+			_, err := api.GetTrips(ctx, "user", "wrong")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("logs in again when the session expired", func() {
+			api.GetTrips(ctx, "user", "pass")
+			server.expire()
+
+			result, err := api.GetTrips(ctx, "user", "pass")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Trips).To(HaveLen(4))
+			Expect(server.Logins()).To(Equal(2))
+		})
+
+		It("logs in again when the session expires during the sync", func() {
+			server.expire_page = 2
+
+			result, err := api.GetTrips(ctx, "user", "pass")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Failed).To(BeEmpty())
+			Expect(result.Trips).To(HaveLen(4))
+			Expect(server.Logins()).To(Equal(2))
+		})
+
+		It("logs in again when the session expires during a full sync", func() {
+			api = new_api(WithFullSync())
+			server.expire_page = 3
+
+			result, err := api.GetTrips(ctx, "user", "pass")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Failed).To(BeEmpty())
+			Expect(result.Trips).To(HaveLen(4))
+			Expect(server.Logins()).To(Equal(2))
+		})
+
+		Context("with a cache", func() {
+			var dir string
+
+			BeforeEach(func() {
+				var err error
+				dir, err = ioutil.TempDir("", "bikage")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(dir)
+			})
+
+			It("stops at the first page that is already cached", func() {
+				api.WithCache(NewJsonCacheAt(filepath.Join(dir, "cache.json")))
+
+				_, err := api.GetTrips(ctx, "user", "pass")
+				Expect(err).NotTo(HaveOccurred())
+
+				result, err := api.GetTrips(ctx, "user", "pass")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(server.Hits()).To(Equal(map[int]int{1: 2, 2: 1, 3: 1}))
+				Expect(result.Trips).To(HaveLen(4))
+			})
+
+			It("caches a partial history and fills the gap on the next sync", func() {
+				cache := NewJsonCacheAt(filepath.Join(dir, "cache.json"))
+				api.WithCache(cache)
+
+				server.fail_page = 2
+				result, err := api.GetTrips(ctx, "user", "pass")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Failed).To(HaveLen(1))
+				Expect(cache.GetTrips("user")).To(HaveLen(3))
+
+				server.fail_page = 0
+				result, err = api.GetTrips(ctx, "user", "pass")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Failed).To(BeEmpty())
+				Expect(cache.GetTrips("user")).To(HaveLen(4))
+				Expect(server.Hits()).To(Equal(map[int]int{1: 2, 2: 2, 3: 2}))
+
+				// The history is complete, back to incremental syncs
+				api.GetTrips(ctx, "user", "pass")
+				Expect(server.Hits()).To(Equal(map[int]int{1: 3, 2: 2, 3: 2}))
+			})
+
+			It("caches again the trips cached with other times", func() {
+				cache := NewJsonCacheAt(filepath.Join(dir, "cache.json"))
+				api.WithCache(cache)
+				api.GetTrips(ctx, "user", "pass")
+
+				// Times read as UTC, with the same ids
+				for _, trip := range cache.GetTrips("user") {
+					trip.StartedAt = trip.StartedAt.Add(4 * time.Hour)
+					trip.EndedAt = trip.EndedAt.Add(4 * time.Hour)
+					cache.PutTrip("user", trip)
+				}
+
+				result, err := api.GetTrips(ctx, "user", "pass")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(server.Hits()).To(Equal(map[int]int{1: 2, 2: 2, 3: 2}))
+				Expect(cache.GetTrips("user")).To(Equal(result.Trips))
+				Expect(result.Trips[len(result.Trips)-1].StartedAt).To(Equal(time.Date(2014, 7, 2, 8, 15, 0, 0, new_york)))
+			})
+
+			It("fetches every page on a full sync", func() {
+				api = new_api(WithFullSync()).WithCache(NewJsonCacheAt(filepath.Join(dir, "cache.json")))
+
+				api.GetTrips(ctx, "user", "pass")
+				api.GetTrips(ctx, "user", "pass")
+				Expect(server.Hits()).To(Equal(map[int]int{1: 2, 2: 2, 3: 2}))
+			})
+		})
+	})
+
+	Describe("Diagnose()", func() {
+		diagnose := func(username, password string) Diagnosis {
+			return api.(Diagnoser).Diagnose(ctx, username, password)
+		}
+
+		It("reads the first page of the history", func() {
+			d := diagnose("user", "pass")
+			Expect(d.Login).NotTo(HaveOccurred())
+			Expect(d.Page).NotTo(HaveOccurred())
+			Expect(d.Profile).To(Equal(SelectorProfiles[0].Name))
+			Expect(d.Navigation).To(BeTrue())
+			Expect(d.Pages).To(Equal(3))
+			Expect(d.Rows).To(Equal(2))
+			Expect(d.Trips).To(Equal(2))
+			Expect(server.Hits()).To(Equal(map[int]int{1: 1}))
+		})
+
+		It("reports login failures", func() {
+			Expect(diagnose("user", "wrong").Login).To(Equal(ErrInvalidCredentials))
+		})
+
+		It("reports trips pages no profile can read", func() {
+			server.page = "profile.html"
+
+			d := diagnose("user", "pass")
+			Expect(d.Login).NotTo(HaveOccurred())
+			Expect(errors.Is(d.Page, ErrLayoutChanged)).To(BeTrue())
+			Expect(d.Profile).To(BeEmpty())
+		})
+	})
+})
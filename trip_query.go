@@ -0,0 +1,243 @@
+package bikage
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TripQuery selects cached trips, zero fields don't filter. Trips are returned
+// sorted by start time.
+type TripQuery struct {
+	// Trips started at or after From and before To
+	From time.Time
+	To   time.Time
+
+	// Station ids
+	StartStation uint64
+	EndStation   uint64
+
+	MinDuration time.Duration
+	MaxDuration time.Duration
+
+	// Weekdays and the time of day the trips started at, in Location (UTC if
+	// nil). After and Before are times since midnight, to the minute, After
+	// can be later than Before to select the night.
+	Weekdays []time.Weekday
+	After    time.Duration
+	Before   time.Duration
+	Location *time.Location
+
+	// Offset and Limit page through the trips, negative values are ignored
+	Offset int
+	Limit  int
+}
+
+func (q TripQuery) location() *time.Location {
+	if q.Location != nil {
+		return q.Location
+	}
+
+	return time.UTC
+}
+
+// Matches tells whether the trip is selected, Offset and Limit aside
+func (q TripQuery) Matches(trip Trip) bool {
+	if !q.From.IsZero() && trip.StartedAt.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !trip.StartedAt.Before(q.To) {
+		return false
+	}
+
+	if q.StartStation != 0 && trip.Route.From.Id != q.StartStation {
+		return false
+	}
+	if q.EndStation != 0 && trip.Route.To.Id != q.EndStation {
+		return false
+	}
+
+	if q.MinDuration > 0 && trip.Duration() < q.MinDuration {
+		return false
+	}
+	if q.MaxDuration > 0 && trip.Duration() > q.MaxDuration {
+		return false
+	}
+
+	started := trip.StartedAt.In(q.location())
+	if len(q.Weekdays) > 0 && !has_weekday(q.Weekdays, started.Weekday()) {
+		return false
+	}
+
+	if q.After > 0 || q.Before > 0 {
+		minutes := started.Hour()*60 + started.Minute()
+		after, before := q.minutes()
+
+		if after <= before && (minutes < after || minutes >= before) {
+			return false
+		}
+		if after > before && minutes < after && minutes >= before {
+			return false
+		}
+	}
+
+	return true
+}
+
+// minutes returns the time of day bounds in minutes since midnight, Before
+// defaults to the end of the day
+func (q TripQuery) minutes() (int, int) {
+	after, before := int(q.After/time.Minute), int(q.Before/time.Minute)
+	if q.Before <= 0 {
+		before = 24 * 60
+	}
+
+	return after, before
+}
+
+// Apply returns the trips selected by the query, sorted by start time
+func (q TripQuery) Apply(trips Trips) Trips {
+	selected := make(Trips, 0)
+	for _, trip := range trips {
+		if q.Matches(trip) {
+			selected = append(selected, trip)
+		}
+	}
+	sort.Sort(selected)
+
+	if q.Offset >= len(selected) {
+		return Trips{}
+	}
+	if q.Offset > 0 {
+		selected = selected[q.Offset:]
+	}
+
+	if q.Limit > 0 && q.Limit < len(selected) {
+		selected = selected[:q.Limit]
+	}
+
+	return selected
+}
+
+func has_weekday(weekdays []time.Weekday, weekday time.Weekday) bool {
+	for _, day := range weekdays {
+		if day == weekday {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseWeekdays parses a comma separated list of days, e.g. mon,tue or
+// saturday,sunday
+func ParseWeekdays(value string) ([]time.Weekday, error) {
+	weekdays := make([]time.Weekday, 0)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		found := false
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			full := strings.ToLower(day.String())
+			if name == full || (len(name) >= 3 && strings.HasPrefix(full, name)) {
+				weekdays = append(weekdays, day)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown day %q", name)
+		}
+	}
+
+	return weekdays, nil
+}
+
+// ParseTimeOfDay parses a time of day written 15:04, and returns the time since
+// midnight
+func ParseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected hh:mm", value)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// TripQueryParams are the parameters read by ParseTripQuery
+var TripQueryParams = []string{
+	"from", "to", "start", "end", "min_duration", "max_duration",
+	"weekdays", "after", "before", "offset", "limit",
+}
+
+// ParseTripQuery reads a query from parameters: from and to are dates
+// (yyyy-mm-dd, both included) in location, start and end station ids,
+// min_duration and max_duration durations (e.g. 10m), weekdays a list of days,
+// after and before times of day (hh:mm), offset and limit numbers.
+func ParseTripQuery(values url.Values, location *time.Location) (TripQuery, error) {
+	r, err := ParseDateRange(values.Get("from"), values.Get("to"))
+	if err != nil {
+		return TripQuery{}, err
+	}
+	query := r.Query(location)
+
+	parse := func(name string, f func(value string) error) {
+		if value := values.Get(name); value != "" && err == nil {
+			if err = f(value); err != nil {
+				err = fmt.Errorf("invalid %s: %v", name, err)
+			}
+		}
+	}
+
+	parse("start", func(value string) (err error) {
+		query.StartStation, err = strconv.ParseUint(value, 10, 64)
+		return
+	})
+	parse("end", func(value string) (err error) {
+		query.EndStation, err = strconv.ParseUint(value, 10, 64)
+		return
+	})
+	parse("min_duration", func(value string) (err error) {
+		query.MinDuration, err = time.ParseDuration(value)
+		return
+	})
+	parse("max_duration", func(value string) (err error) {
+		query.MaxDuration, err = time.ParseDuration(value)
+		return
+	})
+	parse("weekdays", func(value string) (err error) {
+		query.Weekdays, err = ParseWeekdays(value)
+		return
+	})
+	parse("after", func(value string) (err error) {
+		query.After, err = ParseTimeOfDay(value)
+		return
+	})
+	parse("before", func(value string) (err error) {
+		query.Before, err = ParseTimeOfDay(value)
+		return
+	})
+	parse("offset", func(value string) (err error) {
+		query.Offset, err = parse_count(value)
+		return
+	})
+	parse("limit", func(value string) (err error) {
+		query.Limit, err = parse_count(value)
+		return
+	})
+
+	return query, err
+}
+
+// parse_count parses a number of trips, which can't be negative
+func parse_count(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err == nil && n < 0 {
+		err = fmt.Errorf("%d is negative", n)
+	}
+
+	return n, err
+}
@@ -0,0 +1,145 @@
+package bikage_test
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/Bowbaq/bikage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TripQuery", func() {
+	new_york, _ := time.LoadLocation("America/New_York")
+
+	hells_kitchen := Station{Id: 72, Label: "W 52 St & 11 Ave"}
+	tribeca := Station{Id: 79, Label: "Franklin St & W Broadway"}
+
+	trip := func(id string, started time.Time, minutes int, from, to Station) Trip {
+		return Trip{
+			Id:        id,
+			Route:     Route{From: from, To: to},
+			StartedAt: started,
+			EndedAt:   started.Add(time.Duration(minutes) * time.Minute),
+		}
+	}
+
+	// Wednesday morning commute, Wednesday evening back, Saturday night ride,
+	// Sunday ride
+	commute := trip("commute", time.Date(2014, 7, 2, 8, 15, 0, 0, new_york), 14, hells_kitchen, tribeca)
+	back := trip("back", time.Date(2014, 7, 2, 18, 40, 0, 0, new_york), 18, tribeca, hells_kitchen)
+	night := trip("night", time.Date(2014, 7, 5, 23, 30, 0, 0, new_york), 25, hells_kitchen, hells_kitchen)
+	sunday := trip("sunday", time.Date(2014, 7, 6, 11, 0, 0, 0, new_york), 45, tribeca, tribeca)
+
+	trips := Trips{sunday, night, back, commute}
+
+	ids := func(trips Trips) []string {
+		ids := make([]string, 0)
+		for _, trip := range trips {
+			ids = append(ids, trip.Id)
+		}
+		return ids
+	}
+
+	Describe("Apply()", func() {
+		It("returns every trip sorted by start time without filters", func() {
+			Expect(ids(TripQuery{}.Apply(trips))).To(Equal([]string{"commute", "back", "night", "sunday"}))
+		})
+
+		It("selects a date range", func() {
+			query := TripQuery{From: time.Date(2014, 7, 3, 0, 0, 0, 0, new_york), To: time.Date(2014, 7, 6, 0, 0, 0, 0, new_york)}
+			Expect(ids(query.Apply(trips))).To(Equal([]string{"night"}))
+		})
+
+		It("selects the start and end stations", func() {
+			Expect(ids(TripQuery{StartStation: tribeca.Id}.Apply(trips))).To(Equal([]string{"back", "sunday"}))
+			Expect(ids(TripQuery{StartStation: hells_kitchen.Id, EndStation: tribeca.Id}.Apply(trips))).To(Equal([]string{"commute"}))
+		})
+
+		It("selects a duration range", func() {
+			Expect(ids(TripQuery{MinDuration: 15 * time.Minute, MaxDuration: 30 * time.Minute}.Apply(trips))).To(Equal([]string{"back", "night"}))
+		})
+
+		It("selects weekdays in the zone of the query", func() {
+			Expect(ids(TripQuery{Weekdays: []time.Weekday{time.Saturday}, Location: new_york}.Apply(trips))).To(Equal([]string{"night"}))
+			// Saturday 23:30 in New York is Sunday in UTC
+			Expect(ids(TripQuery{Weekdays: []time.Weekday{time.Sunday}}.Apply(trips))).To(Equal([]string{"night", "sunday"}))
+		})
+
+		It("selects the time of day, across midnight", func() {
+			morning := TripQuery{After: 7 * time.Hour, Before: 12 * time.Hour, Location: new_york}
+			Expect(ids(morning.Apply(trips))).To(Equal([]string{"commute", "sunday"}))
+
+			night := TripQuery{After: 22 * time.Hour, Before: 2 * time.Hour, Location: new_york}
+			Expect(ids(night.Apply(trips))).To(Equal([]string{"night"}))
+		})
+
+		It("pages through the trips", func() {
+			Expect(ids(TripQuery{Offset: 1, Limit: 2}.Apply(trips))).To(Equal([]string{"back", "night"}))
+			Expect(TripQuery{Offset: 10}.Apply(trips)).To(BeEmpty())
+			Expect(TripQuery{Offset: -1, Limit: -1}.Apply(trips)).To(HaveLen(4))
+		})
+	})
+
+	Describe("ParseTripQuery()", func() {
+		It("reads the query parameters", func() {
+			values := url.Values{
+				"from":         {"2014-07-02"},
+				"to":           {"2014-07-05"},
+				"start":        {"72"},
+				"min_duration": {"10m"},
+				"weekdays":     {"sat,Sunday"},
+				"after":        {"22:00"},
+				"limit":        {"5"},
+			}
+
+			query, err := ParseTripQuery(values, new_york)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(query.From).To(Equal(time.Date(2014, 7, 2, 0, 0, 0, 0, new_york)))
+			Expect(query.To).To(Equal(time.Date(2014, 7, 6, 0, 0, 0, 0, new_york)))
+			Expect(query.StartStation).To(BeNumerically("==", 72))
+			Expect(query.MinDuration).To(Equal(10 * time.Minute))
+			Expect(query.Weekdays).To(Equal([]time.Weekday{time.Saturday, time.Sunday}))
+			Expect(query.After).To(Equal(22 * time.Hour))
+			Expect(query.Limit).To(Equal(5))
+			Expect(ids(query.Apply(trips))).To(Equal([]string{"night"}))
+		})
+
+		It("rejects invalid parameters", func() {
+			_, err := ParseTripQuery(url.Values{"weekdays": {"someday"}}, new_york)
+			Expect(err).To(MatchError(ContainSubstring("weekdays")))
+
+			_, err = ParseTripQuery(url.Values{"before": {"25:00"}}, new_york)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects a negative offset or limit", func() {
+			_, err := ParseTripQuery(url.Values{"offset": {"-1"}}, new_york)
+			Expect(err).To(MatchError(ContainSubstring("offset")))
+
+			_, err = ParseTripQuery(url.Values{"limit": {"-5"}}, new_york)
+			Expect(err).To(MatchError(ContainSubstring("limit")))
+		})
+	})
+
+	Describe("JsonCache.QueryTrips()", func() {
+		It("runs the query on the cached trips of the user", func() {
+			dir, err := ioutil.TempDir("", "bikage")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			cache := NewJsonCacheAt(filepath.Join(dir, "cache.json"))
+			for _, trip := range trips {
+				cache.PutTrip("user", trip)
+			}
+			cache.PutTrip("other", commute)
+
+			Expect(ids(cache.QueryTrips("user", TripQuery{EndStation: tribeca.Id}))).To(Equal([]string{"commute", "sunday"}))
+			Expect(cache.QueryTrips("nobody", TripQuery{})).To(BeEmpty())
+		})
+	})
+})
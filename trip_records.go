@@ -0,0 +1,223 @@
+package bikage
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// TripRecord is the portable form of a cached trip, used for backups and to
+// move trips between cache backends.
+type TripRecord struct {
+	Trip
+	// Distance is the cached distance of the route in meters, 0 when unknown
+	Distance uint64
+}
+
+// ExportTrips returns the cached trips of the user, along with the cached
+// distance of their routes. No distance is computed.
+func ExportTrips(cache Cache, username string) []TripRecord {
+	records := make([]TripRecord, 0)
+
+	for _, trip := range cache.GetTrips(username) {
+		distance, _ := cache.GetDistance(trip.Route)
+		records = append(records, TripRecord{trip, distance})
+	}
+
+	return records
+}
+
+// ImportTrips stores the records in the cache, skipping the distances that are
+// already known.
+func ImportTrips(cache Cache, username string, records []TripRecord) {
+	for _, record := range records {
+		cache.PutTrip(username, record.Trip)
+
+		if _, ok := cache.GetDistance(record.Route); !ok && record.Distance > 0 {
+			cache.PutDistance(record.Route, record.Distance)
+		}
+	}
+}
+
+var trip_csv_header = []string{
+	"id",
+	"start_station_id", "start_station_label", "start_station_status", "start_station_lat", "start_station_lng",
+	"end_station_id", "end_station_label", "end_station_status", "end_station_lat", "end_station_lng",
+	"started_at", "ended_at",
+	"distance",
+}
+
+func WriteTripsCSV(w io.Writer, records []TripRecord) error {
+	writer := csv.NewWriter(w)
+	writer.Write(trip_csv_header)
+
+	for _, r := range records {
+		row := []string{r.Id}
+		row = append(row, station_csv_fields(r.Route.From)...)
+		row = append(row, station_csv_fields(r.Route.To)...)
+		row = append(row,
+			r.StartedAt.Format(time.RFC3339),
+			r.EndedAt.Format(time.RFC3339),
+			strconv.FormatUint(r.Distance, 10),
+		)
+
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func station_csv_fields(s Station) []string {
+	return []string{
+		strconv.FormatUint(s.Id, 10),
+		s.Label,
+		strconv.Itoa(s.Status),
+		strconv.FormatFloat(s.Lat, 'f', -1, 64),
+		strconv.FormatFloat(s.Lng, 'f', -1, 64),
+	}
+}
+
+// ReadTripsCSV reads records written by WriteTripsCSV, columns are matched by
+// header name.
+func ReadTripsCSV(r io.Reader) ([]TripRecord, error) {
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range trip_csv_header {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("trips CSV is missing column %q", name)
+		}
+	}
+
+	records := make([]TripRecord, 0)
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		p := csv_row_parser{row: row, columns: columns}
+
+		record := TripRecord{
+			Trip: Trip{
+				Id: p.string("id"),
+				Route: Route{
+					From: p.station("start_station_"),
+					To:   p.station("end_station_"),
+				},
+				StartedAt: p.time("started_at"),
+				EndedAt:   p.time("ended_at"),
+			},
+			Distance: p.uint("distance"),
+		}
+
+		if p.err != nil {
+			return nil, fmt.Errorf("trips CSV line %d: %v", line, p.err)
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// csv_row_parser reads typed fields from a CSV row, the first error is kept in
+// err and later fields are parsed as zero values.
+type csv_row_parser struct {
+	row     []string
+	columns map[string]int
+	err     error
+}
+
+func (p *csv_row_parser) string(name string) string {
+	return p.row[p.columns[name]]
+}
+
+func (p *csv_row_parser) uint(name string) uint64 {
+	value, err := strconv.ParseUint(p.string(name), 10, 64)
+	p.fail(name, err)
+	return value
+}
+
+func (p *csv_row_parser) int(name string) int {
+	value, err := strconv.Atoi(p.string(name))
+	p.fail(name, err)
+	return value
+}
+
+func (p *csv_row_parser) float(name string) float64 {
+	value, err := strconv.ParseFloat(p.string(name), 64)
+	p.fail(name, err)
+	return value
+}
+
+func (p *csv_row_parser) time(name string) time.Time {
+	value, err := time.Parse(time.RFC3339, p.string(name))
+	p.fail(name, err)
+	return value
+}
+
+func (p *csv_row_parser) station(prefix string) Station {
+	return Station{
+		Id:     p.uint(prefix + "id"),
+		Label:  p.string(prefix + "label"),
+		Status: p.int(prefix + "status"),
+		Lat:    p.float(prefix + "lat"),
+		Lng:    p.float(prefix + "lng"),
+	}
+}
+
+func (p *csv_row_parser) fail(name string, err error) {
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("invalid %s: %v", name, err)
+	}
+}
+
+// WriteTripsJSONL writes one JSON encoded record per line
+func WriteTripsJSONL(w io.Writer, records []TripRecord) error {
+	encoder := json.NewEncoder(w)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func ReadTripsJSONL(r io.Reader) ([]TripRecord, error) {
+	records := make([]TripRecord, 0)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record TripRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("trips JSONL line %d: %v", line, err)
+		}
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
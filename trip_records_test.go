@@ -0,0 +1,72 @@
+package bikage_test
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	. "github.com/Bowbaq/bikage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TripRecord", func() {
+	started_at := time.Date(2014, 7, 1, 8, 0, 0, 0, time.UTC)
+
+	records := []TripRecord{
+		{
+			Trip: Trip{
+				Id: "1",
+				Route: Route{
+					From: Station{Id: 72, Label: "W 52 St & 11 Ave", Status: 1, Lat: 40.76727216, Lng: -73.99392888},
+					To:   Station{Id: 79, Label: "Franklin St, West Broadway", Status: 1, Lat: 40.71911552, Lng: -74.00666661},
+				},
+				StartedAt: started_at,
+				EndedAt:   started_at.Add(20 * time.Minute),
+			},
+			Distance: 6150,
+		},
+		{
+			Trip: Trip{
+				Id: "2",
+				Route: Route{
+					From: Station{Id: 79, Label: "Franklin St, West Broadway", Status: 1, Lat: 40.71911552, Lng: -74.00666661},
+					To:   Station{Id: 72, Label: "W 52 St & 11 Ave", Status: 1, Lat: 40.76727216, Lng: -73.99392888},
+				},
+				StartedAt: started_at.Add(10 * time.Hour),
+				EndedAt:   started_at.Add(10*time.Hour + 22*time.Minute),
+			},
+		},
+	}
+
+	It("round-trips through CSV", func() {
+		var buf bytes.Buffer
+		Expect(WriteTripsCSV(&buf, records)).To(Succeed())
+
+		read, err := ReadTripsCSV(&buf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read).To(Equal(records))
+	})
+
+	It("round-trips through JSON Lines", func() {
+		var buf bytes.Buffer
+		Expect(WriteTripsJSONL(&buf, records)).To(Succeed())
+		Expect(strings.Count(buf.String(), "\n")).To(Equal(2))
+
+		read, err := ReadTripsJSONL(&buf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read).To(HaveLen(2))
+		Expect(read[0].Route).To(Equal(records[0].Route))
+		Expect(read[0].StartedAt.Equal(records[0].StartedAt)).To(BeTrue())
+		Expect(read[0].Distance).To(BeNumerically("==", 6150))
+	})
+
+	It("reports the line of invalid CSV rows", func() {
+		var buf bytes.Buffer
+		WriteTripsCSV(&buf, records)
+
+		_, err := ReadTripsCSV(strings.NewReader(strings.Replace(buf.String(), "6150", "far", 1)))
+		Expect(err).To(MatchError(ContainSubstring("line 2")))
+	})
+})
@@ -23,6 +23,51 @@ type Trip struct {
 
 type Trips []Trip
 
+// RoutedTrip is a trip along with the distance and geometry of its route. Path
+// is empty when the router can't provide a geometry.
+type RoutedTrip struct {
+	Trip
+	Distance uint64
+	Path     Path
+}
+
+// Reasons why a trip couldn't be parsed
+const (
+	SkipInvalidTime    = "invalid_time"
+	SkipUnknownStation = "unknown_station"
+	SkipMalformedRow   = "malformed_row"
+)
+
+// SkippedTrip is a row of the trip history that couldn't be parsed. Page is
+// the history page it was found on, or File and Row the system data file and
+// line. Raw is its text content.
+type SkippedTrip struct {
+	Page   int
+	File   string
+	Row    int
+	Raw    string
+	Reason string
+	Error  string
+}
+
+type SkippedTrips []SkippedTrip
+
+// FailedPage is a page of the trip history that couldn't be fetched
+type FailedPage struct {
+	Page  int
+	Error string
+}
+
+// TripsResult holds the trips fetched from a TripAPI, along with the rows that
+// had to be skipped and the pages that couldn't be fetched. Profile is the name
+// of the SelectorProfile the history was read with, if any.
+type TripsResult struct {
+	Trips   Trips
+	Skipped SkippedTrips
+	Failed  []FailedPage
+	Profile string
+}
+
 func (t Trips) Len() int           { return len(t) }
 func (t Trips) Less(i, j int) bool { return t[i].StartedAt.Before(t[j].StartedAt) }
 func (t Trips) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
@@ -36,6 +81,18 @@ func (t Trip) String() string {
 	)
 }
 
+func (s SkippedTrip) String() string {
+	if s.File != "" {
+		return fmt.Sprintf("%s row %d: %s (%s)", s.File, s.Row, s.Error, s.Raw)
+	}
+
+	return fmt.Sprintf("page %d: %s (%s)", s.Page, s.Error, s.Raw)
+}
+
+func (f FailedPage) String() string {
+	return fmt.Sprintf("page %d: %s", f.Page, f.Error)
+}
+
 func (t Trip) Duration() time.Duration {
 	return t.EndedAt.Sub(t.StartedAt)
 }